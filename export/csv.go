@@ -0,0 +1,28 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// CSV writes the results with a row for each competitor, grouped by course
+func CSV(w io.Writer, rs liveo.ResultDataSet) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"Course", "Position", "Name", "Age Class", "Club", "Time", "Behind", "Status"})
+	for _, c := range rs.Results.Courses {
+		for _, r := range ranked(c) {
+			position, behind, status := "", "", "Invalid"
+			if r.Valid {
+				position = strconv.Itoa(r.Position)
+				behind = FormatTime(r.Behind)
+				status = "OK"
+			}
+			out.Write([]string{c.Title, position, r.Name, r.AgeClass, r.Club, FormatTime(r.Time), behind, status})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,122 @@
+// Package export writes results in the formats organisers want after an event: CSV for
+// spreadsheets, IOF XML 3.0 for other orienteering software, and HTML laid out for printing.
+// Handler serves them over HTTP, as resultserver and filewatcher both do.
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// now is the time exports are stamped with
+var now = time.Now
+
+// A Format is a format results can be exported in
+type Format struct {
+	Extension   string
+	ContentType string
+	// Attachment is whether browsers should download the export rather than show it
+	Attachment bool
+	Write      func(w io.Writer, rs liveo.ResultDataSet) error
+}
+
+// Formats are the formats Handler serves, by file extension
+var Formats = map[string]Format{
+	"csv":  {"csv", "text/csv; charset=utf-8", true, CSV},
+	"xml":  {"xml", "application/xml; charset=utf-8", true, IOFXML},
+	"html": {"html", "text/html; charset=utf-8", false, PrintableHTML},
+}
+
+// Handler serves the results current returns as results.csv, results.xml and results.html
+// under prefix
+func Handler(prefix string, current func() liveo.ResultDataSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, prefix)
+		format, found := Formats[strings.TrimPrefix(name, "results.")]
+		if !found || !strings.HasPrefix(name, "results.") {
+			http.NotFound(w, req)
+			return
+		}
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rs := current()
+		// exports are written in full first so that a failure can still be reported as one
+		var exported bytes.Buffer
+		if err := format.Write(&exported, rs); err != nil {
+			http.Error(w, "failed to export results", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", format.ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(exported.Len()))
+		if format.Attachment {
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", FileName(rs.Results.Title, format.Extension)))
+		}
+		if req.Method == http.MethodHead {
+			return
+		}
+		w.Write(exported.Bytes())
+	})
+}
+
+// FileName returns a file name for results of the event titled title
+func FileName(title, extension string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, title)
+	for strings.Contains(name, "--") {
+		name = strings.ReplaceAll(name, "--", "-")
+	}
+	if name = strings.Trim(name, "-"); name == "" {
+		name = "results"
+	}
+	return name + "." + extension
+}
+
+// FormatTime formats a time as m:ss, or h:mm:ss from an hour
+func FormatTime(t time.Duration) string {
+	seconds := int64(t / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// result is a competitor with their standing
+type result struct {
+	liveo.Competitor
+	liveo.Standing
+}
+
+// ranked returns the competitors of c in order of position, followed by those without a
+// valid result in the order given
+func ranked(c liveo.Course) []result {
+	standings := liveo.Rank(c)
+	results := make([]result, len(c.Competitors))
+	for i, cp := range c.Competitors {
+		results[i] = result{cp, standings[i]}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Valid != results[j].Valid {
+			return results[i].Valid
+		}
+		return results[i].Position < results[j].Position
+	})
+	return results
+}
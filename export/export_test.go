@@ -0,0 +1,123 @@
+package export
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// exportTestResults covers what the exporters distinguish: ties, clubs or none, a course length,
+// and invalid results with and without a time
+func exportTestResults() liveo.ResultDataSet {
+	r := liveo.Results{
+		Title: "Autumn Middle",
+		Courses: []liveo.Course{
+			{
+				Title: "Brown",
+				Info:  "6.3km 210m",
+				Competitors: []liveo.Competitor{
+					{Name: "Ann Baker", AgeClass: "W21", Club: "SYO", Time: 52*time.Minute + 7*time.Second, Valid: true},
+					{Name: "Carl de Vries", AgeClass: "M35", Club: "EPOC", Time: 48*time.Minute + 30*time.Second, Valid: true},
+					{Name: "Dee", AgeClass: "W40", Time: 52*time.Minute + 7*time.Second, Valid: true},
+					{Name: "Ed Fox", AgeClass: "M21", Club: "SYO", Time: 45 * time.Minute, Valid: false},
+					{Name: "Gil Hart", AgeClass: "M50", Club: "DVO", Valid: false},
+				},
+			},
+			{
+				Title: "Yellow, short",
+				Competitors: []liveo.Competitor{
+					{ID: "2071", Name: "Ivy \"Jo\" King", AgeClass: "W10", Club: "SYO", Time: time.Hour + 2*time.Minute + 3*time.Second, Valid: true},
+				},
+			},
+		},
+	}
+	return liveo.ResultDataSet{Results: r, Hash: liveo.HashResults(r)}
+}
+
+// exportFixedTime stands in for the time of export, so that exports can be compared
+var exportFixedTime = time.Date(2026, time.October, 18, 14, 30, 0, 0, time.UTC)
+
+func TestGoldenExports(t *testing.T) {
+	defer func(original func() time.Time) { now = original }(now)
+	now = func() time.Time { return exportFixedTime }
+
+	for _, test := range []struct {
+		golden string
+		write  func(io.Writer, liveo.ResultDataSet) error
+	}{
+		{"results.csv", CSV},
+		{"results.xml", IOFXML},
+	} {
+		t.Run(test.golden, func(t *testing.T) {
+			var got bytes.Buffer
+			if err := test.write(&got, exportTestResults()); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", test.golden)
+			if *update {
+				if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("export differs from %s (rerun with -update if intended):\n%s", golden, got.String())
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rs := exportTestResults()
+	handler := Handler("/export/", func() liveo.ResultDataSet { return rs })
+
+	var csv bytes.Buffer
+	if err := CSV(&csv, rs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method, path string
+		status       int
+		body         []byte
+	}{
+		{http.MethodGet, "/export/results.csv", http.StatusOK, csv.Bytes()},
+		{http.MethodHead, "/export/results.csv", http.StatusOK, nil},
+		{http.MethodGet, "/export/results.pdf", http.StatusNotFound, nil},
+		{http.MethodGet, "/export/other.csv", http.StatusNotFound, nil},
+		{http.MethodPost, "/export/results.csv", http.StatusMethodNotAllowed, nil},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s %s: status %d, want %d", test.method, test.path, rec.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="autumn-middle.csv"` {
+			t.Errorf("%s %s: Content-Disposition %q", test.method, test.path, got)
+		}
+		if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(csv.Len()) {
+			t.Errorf("%s %s: Content-Length %s, want %d", test.method, test.path, got, csv.Len())
+		}
+		if !bytes.Equal(rec.Body.Bytes(), test.body) {
+			t.Errorf("%s %s: body\n%s\nwant\n%s", test.method, test.path, rec.Body.Bytes(), test.body)
+		}
+	}
+}
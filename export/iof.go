@@ -0,0 +1,138 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// IOF XML 3.0 ResultList, as far as the results allow. Each course is a class. Competitors'
+// names are split into given and family names at the last space. The reason a result is invalid
+// isn't known, so those without a time have the status DidNotFinish and the rest MissingPunch.
+// Age classes have no place in the format.
+type iofResultList struct {
+	XMLName     xml.Name         `xml:"ResultList"`
+	Namespace   string           `xml:"xmlns,attr"`
+	IOFVersion  string           `xml:"iofVersion,attr"`
+	CreateTime  string           `xml:"createTime,attr"`
+	Creator     string           `xml:"creator,attr"`
+	Status      string           `xml:"status,attr"`
+	Event       iofEvent         `xml:"Event"`
+	ClassResult []iofClassResult `xml:"ClassResult"`
+}
+
+type iofEvent struct {
+	Name string `xml:"Name"`
+}
+
+type iofClassResult struct {
+	Class        iofClass          `xml:"Class"`
+	Course       iofCourse         `xml:"Course"`
+	PersonResult []iofPersonResult `xml:"PersonResult"`
+}
+
+type iofClass struct {
+	ID   string `xml:"Id,omitempty"`
+	Name string `xml:"Name"`
+}
+
+type iofCourse struct {
+	Name   string `xml:"Name"`
+	Length int    `xml:"Length,omitempty"`
+}
+
+type iofPersonResult struct {
+	Person       iofPerson        `xml:"Person"`
+	Organisation *iofOrganisation `xml:"Organisation,omitempty"`
+	Result       iofResult        `xml:"Result"`
+}
+
+type iofPerson struct {
+	ID   string        `xml:"Id,omitempty"`
+	Name iofPersonName `xml:"Name"`
+}
+
+type iofPersonName struct {
+	Family string `xml:"Family"`
+	Given  string `xml:"Given"`
+}
+
+type iofOrganisation struct {
+	Name string `xml:"Name"`
+}
+
+type iofResult struct {
+	Time       *int64 `xml:"Time,omitempty"`
+	TimeBehind *int64 `xml:"TimeBehind,omitempty"`
+	Position   int    `xml:"Position,omitempty"`
+	Status     string `xml:"Status"`
+}
+
+// IOFXML writes the results as an IOF XML 3.0 ResultList
+func IOFXML(w io.Writer, rs liveo.ResultDataSet) error {
+	list := iofResultList{
+		Namespace:  "http://www.orienteering.org/datastandard/3.0",
+		IOFVersion: "3.0",
+		CreateTime: now().Format(time.RFC3339),
+		Creator:    "live-o-results",
+		Status:     "Snapshot",
+		Event:      iofEvent{Name: rs.Results.Title},
+	}
+	for _, c := range rs.Results.Courses {
+		class := iofClassResult{
+			Class:  iofClass{ID: c.ID, Name: c.Title},
+			Course: iofCourse{Name: c.Title},
+		}
+		if km, found := c.Distance(); found {
+			class.Course.Length = int(math.Round(km * 1000))
+		}
+		for _, r := range ranked(c) {
+			given, family := splitName(r.Name)
+			pr := iofPersonResult{
+				Person: iofPerson{ID: r.ID, Name: iofPersonName{Family: family, Given: given}},
+				Result: iofResult{Status: "MissingPunch"},
+			}
+			if r.Club != "" {
+				pr.Organisation = &iofOrganisation{Name: r.Club}
+			}
+			if r.Time > 0 {
+				seconds := int64(r.Time / time.Second)
+				pr.Result.Time = &seconds
+			} else {
+				pr.Result.Status = "DidNotFinish"
+			}
+			if r.Valid {
+				behind := int64(r.Behind / time.Second)
+				pr.Result.TimeBehind = &behind
+				pr.Result.Position = r.Position
+				pr.Result.Status = "OK"
+			}
+			class.PersonResult = append(class.PersonResult, pr)
+		}
+		list.ClassResult = append(list.ClassResult, class)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(list); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// splitName splits a name into given and family names at the last space
+func splitName(name string) (given, family string) {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndex(name, " "); i >= 0 {
+		return strings.TrimSpace(name[:i]), name[i+1:]
+	}
+	return "", name
+}
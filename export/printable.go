@@ -0,0 +1,43 @@
+package export
+
+import (
+	"embed"
+	"html/template"
+	"io"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+//go:embed templates/printable.html
+var templateFiles embed.FS
+
+var printableTemplate = template.Must(template.New("printable.html").Funcs(template.FuncMap{
+	"formatTime": FormatTime,
+}).ParseFS(templateFiles, "templates/printable.html"))
+
+type printableCourse struct {
+	liveo.Course
+	Results []result
+}
+
+type printablePage struct {
+	Title   string
+	Printed string
+	Courses []printableCourse
+}
+
+// PrintableHTML writes the results as an HTML page laid out for printing, each course starting
+// on a new page
+func PrintableHTML(w io.Writer, rs liveo.ResultDataSet) error {
+	page := printablePage{
+		Title:   rs.Results.Title,
+		Printed: now().Format("2 Jan 2006 15:04"),
+	}
+	if page.Title == "" {
+		page.Title = "Results"
+	}
+	for _, c := range rs.Results.Courses {
+		page.Courses = append(page.Courses, printableCourse{c, ranked(c)})
+	}
+	return printableTemplate.Execute(w, page)
+}
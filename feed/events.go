@@ -0,0 +1,132 @@
+package feed
+
+import (
+	"sort"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// Event types
+const (
+	EventFinisher  = "finisher"
+	EventLeader    = "leader"
+	EventCorrected = "corrected"
+	EventRemoved   = "removed"
+)
+
+// Event is something that happened on a course, derived from a change of results
+type Event struct {
+	Seq         int64       `json:"seq" doc:"Increases by one with each event, so clients can ask for events since the last they saw"`
+	Type        string      `json:"type" enum:"finisher,leader,corrected,removed" doc:"A new finisher, a new leader of the course, a corrected result or a removed result"`
+	Time        string      `json:"time" doc:"When the server received the change, in RFC 3339 format"`
+	Hash        string      `json:"hash" doc:"Hash of the results the event was derived from"`
+	Course      string      `json:"course" doc:"Title of the course"`
+	CourseIndex int         `json:"courseIndex"`
+	Competitor  *Competitor `json:"competitor,omitempty" doc:"The competitor, with their standing, for all but removed events"`
+	Previous    *Competitor `json:"previous,omitempty" doc:"For corrected and removed events the competitor as they were, and for leader events the previous leader if any"`
+}
+
+// Events derives the events of the change d makes from A to B. Events are ordered by course,
+// then position.
+func Events(A liveo.ResultDataSet, d liveo.ResultDelta, B liveo.ResultDataSet, at time.Time) []Event {
+	events := []Event{}
+	sources := d.CourseSources(A)
+	competitorDeltas := map[int]liveo.CompetitorsDelta{}
+	if d.Competitors != nil {
+		competitorDeltas = *d.Competitors
+	}
+
+	for j, course := range B.Results.Courses {
+		compDelta, changed := competitorDeltas[j]
+		var old liveo.Course
+		if a, found := sources[j]; found {
+			if !changed {
+				continue
+			}
+			old = A.Results.Courses[a]
+		} else {
+			// a new course, all of whose competitors are new
+			compDelta = liveo.CompetitorsDelta{Added: map[int]liveo.Competitor{}}
+			for k, cp := range course.Competitors {
+				compDelta.Added[k] = cp
+			}
+		}
+
+		newCourse := fromCourse(course)
+		oldCourse := fromCourse(old)
+		event := func(eventType string, competitor, previous *Competitor) Event {
+			return Event{
+				Type:        eventType,
+				Time:        at.Format(time.RFC3339),
+				Hash:        FormatHash(B.Hash),
+				Course:      course.Title,
+				CourseIndex: j,
+				Competitor:  competitor,
+				Previous:    previous,
+			}
+		}
+
+		courseEvents := []Event{}
+		for _, k := range sortedKeys(compDelta.Added) {
+			if k < len(newCourse.Competitors) {
+				courseEvents = append(courseEvents, event(EventFinisher, &newCourse.Competitors[k], nil))
+			}
+		}
+		from := map[int]bool{}
+		for _, k := range sortedKeys(compDelta.Changed) {
+			change := compDelta.Changed[k]
+			from[change.From] = true
+			if k < len(newCourse.Competitors) && change.From < len(oldCourse.Competitors) {
+				courseEvents = append(courseEvents, event(EventCorrected, &newCourse.Competitors[k], &oldCourse.Competitors[change.From]))
+			}
+		}
+		for _, i := range sortedKeys(compDelta.Removed) {
+			if !from[i] && i < len(oldCourse.Competitors) {
+				courseEvents = append(courseEvents, event(EventRemoved, nil, &oldCourse.Competitors[i]))
+			}
+		}
+
+		// The lead changes hands only if none of those leading before still leads, so a
+		// competitor equalling the leader's time isn't a new leader
+		leadersBefore := map[string]bool{}
+		var previousLeader *Competitor
+		for i, cp := range oldCourse.Competitors {
+			if cp.Position == 1 {
+				leadersBefore[cp.ID] = true
+				if previousLeader == nil {
+					previousLeader = &oldCourse.Competitors[i]
+				}
+			}
+		}
+		newLeaders := []int{}
+		for k, cp := range newCourse.Competitors {
+			if cp.Position != 1 {
+				continue
+			}
+			if leadersBefore[cp.ID] {
+				newLeaders = nil
+				break
+			}
+			newLeaders = append(newLeaders, k)
+		}
+		for _, k := range newLeaders {
+			courseEvents = append(courseEvents, event(EventLeader, &newCourse.Competitors[k], previousLeader))
+		}
+
+		sort.SliceStable(courseEvents, func(x, y int) bool {
+			return eventPosition(courseEvents[x]) < eventPosition(courseEvents[y])
+		})
+		events = append(events, courseEvents...)
+	}
+	return events
+}
+
+// eventPosition orders events within a course by position, with competitors without a position
+// and removed competitors last
+func eventPosition(e Event) int {
+	if e.Competitor == nil || e.Competitor.Position == 0 {
+		return int(^uint(0) >> 1)
+	}
+	return e.Competitor.Position
+}
@@ -0,0 +1,100 @@
+package feed
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// eventsTestSet returns a result set with a course of the given competitors for each list
+func eventsTestSet(courses ...[]liveo.Competitor) liveo.ResultDataSet {
+	r := liveo.Results{Title: "Event"}
+	for i, competitors := range courses {
+		r.Courses = append(r.Courses, liveo.Course{Title: []string{"Brown", "Blue"}[i], Competitors: competitors})
+	}
+	return liveo.ResultDataSet{Results: r, Hash: liveo.HashResults(r)}
+}
+
+func finisher(name string, minutes int) liveo.Competitor {
+	return liveo.Competitor{Name: name, Time: time.Duration(minutes) * time.Minute, Valid: true}
+}
+
+// describeEvent summarises an event as type, course, competitor and previous competitor
+func describeEvent(e Event) string {
+	describe := func(c *Competitor) string {
+		if c == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%s@%d", c.Name, c.TimeMs/60000)
+	}
+	return fmt.Sprintf("%s %s %s %s", e.Type, e.Course, describe(e.Competitor), describe(e.Previous))
+}
+
+func TestEvents(t *testing.T) {
+	ann, bob, cat := finisher("Ann", 50), finisher("Bob", 40), finisher("Cat", 45)
+	annCorrected := finisher("Ann", 48)
+	for _, test := range []struct {
+		name          string
+		before, after liveo.ResultDataSet
+		want          []string
+	}{
+		{
+			name:   "new finisher takes the lead",
+			before: eventsTestSet([]liveo.Competitor{ann}),
+			after:  eventsTestSet([]liveo.Competitor{ann, bob}),
+			want:   []string{"finisher Brown Bob@40 -", "leader Brown Bob@40 Ann@50"},
+		},
+		{
+			name:   "new finisher behind the leader",
+			before: eventsTestSet([]liveo.Competitor{bob}),
+			after:  eventsTestSet([]liveo.Competitor{bob, cat}),
+			want:   []string{"finisher Brown Cat@45 -"},
+		},
+		{
+			name:   "tie for the lead",
+			before: eventsTestSet([]liveo.Competitor{bob}),
+			after:  eventsTestSet([]liveo.Competitor{bob, finisher("Dan", 40)}),
+			want:   []string{"finisher Brown Dan@40 -"},
+		},
+		{
+			name:   "corrected time",
+			before: eventsTestSet([]liveo.Competitor{bob, ann}),
+			after:  eventsTestSet([]liveo.Competitor{bob, annCorrected}),
+			want:   []string{"corrected Brown Ann@48 Ann@50"},
+		},
+		{
+			name:   "removed competitor",
+			before: eventsTestSet([]liveo.Competitor{bob, cat, ann}),
+			after:  eventsTestSet([]liveo.Competitor{bob, ann}),
+			want:   []string{"removed Brown - Cat@45"},
+		},
+		{
+			name:   "new course",
+			before: eventsTestSet([]liveo.Competitor{bob}),
+			after:  eventsTestSet([]liveo.Competitor{bob}, []liveo.Competitor{ann, cat}),
+			want:   []string{"finisher Blue Cat@45 -", "leader Blue Cat@45 -", "finisher Blue Ann@50 -"},
+		},
+		{
+			name:   "unchanged",
+			before: eventsTestSet([]liveo.Competitor{bob}),
+			after:  eventsTestSet([]liveo.Competitor{bob}),
+			want:   []string{},
+		},
+	} {
+		at := time.Date(2026, time.October, 18, 11, 0, 0, 0, time.UTC)
+		events := Events(test.before, test.before.DeltaTo(test.after), test.after, at)
+		got := []string{}
+		for _, e := range events {
+			got = append(got, describeEvent(e))
+			if e.Hash != FormatHash(test.after.Hash) || e.Time != "2026-10-18T11:00:00Z" {
+				t.Errorf("%s: event %s has hash %s and time %s", test.name, e.Type, e.Hash, e.Time)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: events\n%q\nwant\n%q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Package feed defines the versioned JSON format in which results are published to viewers.
+// Unlike the liveo types it is intended for third parties: times are in milliseconds, competitors
+// carry their position and status, hashes are strings so they survive Javascript numbers, and
+// lists are changed by arrays of operations rather than maps with integer keys. Standings are
+// computed by the server so that every client shows the same numbers. Schema describes
+// the format as a JSON Schema.
+package feed
+
+//go:generate go run ./gen
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// Version is the version of the format, given in every Results and Delta
+const Version = 1
+
+// Competitor statuses
+const (
+	StatusOK      = "ok"
+	StatusInvalid = "invalid"
+)
+
+// Results is a full set of results
+type Results struct {
+	Version int      `json:"version" doc:"Version of the feed format"`
+	Hash    string   `json:"hash" doc:"Identifies the results. Deltas give the hash they apply to and the hash they produce"`
+	Title   string   `json:"title"`
+	Courses []Course `json:"courses"`
+}
+
+// Course is a course and its competitors in finishing order
+type Course struct {
+	ID          string       `json:"id,omitempty" doc:"Stable identifier of the course, if the results provide one"`
+	Title       string       `json:"title"`
+	Info        string       `json:"info" doc:"Course details such as length and climb"`
+	DistanceKm  float64      `json:"distanceKm,omitempty" doc:"Length of the course, if given in its info"`
+	Competitors []Competitor `json:"competitors"`
+}
+
+// Competitor is a competitor's result and standing
+type Competitor struct {
+	ID string `json:"id" doc:"Stable identifier of the competitor, such as an SI card number, or otherwise derived from name and club"`
+	Standing
+	Name     string `json:"name"`
+	AgeClass string `json:"ageClass"`
+	Club     string `json:"club"`
+	TimeMs   int64  `json:"timeMs" doc:"Elapsed time in milliseconds"`
+	Status   string `json:"status" enum:"ok,invalid" doc:"Whether the competitor has a valid result"`
+}
+
+// Standing is a competitor's standing on their course. Fields are absent for competitors
+// without a valid result, and where zero.
+type Standing struct {
+	Position      int     `json:"position,omitempty" doc:"Position on the course. Competitors on equal times share a position"`
+	BehindMs      int64   `json:"behindMs,omitempty" doc:"Time behind the winner in milliseconds"`
+	PercentBehind float64 `json:"percentBehind,omitempty" doc:"Time behind the winner as a percentage of the winner's time, to one decimal place"`
+	PaceMsPerKm   int64   `json:"paceMsPerKm,omitempty" doc:"Time per km in milliseconds, if the course's distance is known"`
+}
+
+// Delta changes one set of Results into another
+type Delta struct {
+	Version     int                `json:"version" doc:"Version of the feed format"`
+	Old         string             `json:"old" doc:"Hash of the results the delta applies to"`
+	New         string             `json:"new" doc:"Hash of the results the delta produces"`
+	Title       *string            `json:"title,omitempty" doc:"New title, if changed"`
+	Courses     *CoursesDelta      `json:"courses,omitempty"`
+	Competitors []CompetitorsDelta `json:"competitors,omitempty" doc:"Changes to the competitors of each course, applied after changes to courses"`
+}
+
+// CoursesDelta changes the list of courses. Courses at the removed indices of the old list are
+// removed, then added and modified courses are placed at their indices in the new list, with the
+// remaining courses filling the gaps in order.
+type CoursesDelta struct {
+	Removed  []int          `json:"removed" doc:"Indices in the old list of courses removed, including those modified"`
+	Added    []PlacedCourse `json:"added"`
+	Modified []CourseChange `json:"modified,omitempty"`
+}
+
+// PlacedCourse is a course added at an index of the new list
+type PlacedCourse struct {
+	Index  int    `json:"index"`
+	Course Course `json:"course"`
+}
+
+// CourseChange places the course at From in the old list at Index in the new list, with its
+// details changed. Its competitors are kept.
+type CourseChange struct {
+	Index int     `json:"index"`
+	From  int     `json:"from"`
+	Title *string `json:"title,omitempty"`
+	Info  *string `json:"info,omitempty"`
+}
+
+// CompetitorsDelta changes the competitors of a course in the same way as CoursesDelta
+type CompetitorsDelta struct {
+	Course    int                `json:"course" doc:"Index of the course in the new list"`
+	Removed   []int              `json:"removed" doc:"Indices in the old list of competitors removed, including those changed"`
+	Added     []PlacedCompetitor `json:"added"`
+	Changed   []CompetitorChange `json:"changed,omitempty"`
+	Standings []Standing         `json:"standings" doc:"Standing of every competitor of the course after the delta, which may change as competitors finish"`
+}
+
+// PlacedCompetitor is a competitor added at an index of the new list
+type PlacedCompetitor struct {
+	Index      int        `json:"index"`
+	Competitor Competitor `json:"competitor"`
+}
+
+// CompetitorChange places the competitor at From in the old list at Index in the new list, with
+// its details corrected
+type CompetitorChange struct {
+	Index    int     `json:"index"`
+	From     int     `json:"from"`
+	ID       string  `json:"id" doc:"Identifier of the competitor after the change"`
+	Name     *string `json:"name,omitempty"`
+	AgeClass *string `json:"ageClass,omitempty"`
+	Club     *string `json:"club,omitempty"`
+	TimeMs   *int64  `json:"timeMs,omitempty"`
+	Status   *string `json:"status,omitempty" enum:"ok,invalid"`
+}
+
+// FormatHash returns a hash as used in the feed
+func FormatHash(hash uint64) string {
+	return strconv.FormatUint(hash, 10)
+}
+
+// FromResults converts a result set to the feed format
+func FromResults(rs liveo.ResultDataSet) Results {
+	results := Results{
+		Version: Version,
+		Hash:    FormatHash(rs.Hash),
+		Title:   rs.Results.Title,
+		Courses: make([]Course, len(rs.Results.Courses)),
+	}
+	for i, c := range rs.Results.Courses {
+		results.Courses[i] = fromCourse(c)
+	}
+	return results
+}
+
+func fromCourse(c liveo.Course) Course {
+	course := Course{
+		ID:          c.ID,
+		Title:       c.Title,
+		Info:        c.Info,
+		Competitors: make([]Competitor, len(c.Competitors)),
+	}
+	if km, found := c.Distance(); found {
+		course.DistanceKm = km
+	}
+	standings := Standings(c)
+	for i, cp := range c.Competitors {
+		course.Competitors[i] = fromCompetitor(cp)
+		course.Competitors[i].Standing = standings[i]
+	}
+	return course
+}
+
+func fromCompetitor(c liveo.Competitor) Competitor {
+	return Competitor{
+		ID:       c.Identity(),
+		Name:     c.Name,
+		AgeClass: c.AgeClass,
+		Club:     c.Club,
+		TimeMs:   timeMs(c.Time),
+		Status:   status(c.Valid),
+	}
+}
+
+func timeMs(t time.Duration) int64 {
+	return int64(t / time.Millisecond)
+}
+func status(valid bool) string {
+	if valid {
+		return StatusOK
+	}
+	return StatusInvalid
+}
+
+// Standings returns the standing of each competitor of a course
+func Standings(c liveo.Course) []Standing {
+	ranked := liveo.Rank(c)
+	standings := make([]Standing, len(ranked))
+	for i, r := range ranked {
+		standings[i] = Standing{
+			Position:      r.Position,
+			BehindMs:      timeMs(r.Behind),
+			PercentBehind: math.Round(r.PercentBehind*10) / 10,
+			PaceMsPerKm:   timeMs(r.Pace),
+		}
+	}
+	return standings
+}
+
+// FromDelta converts a delta to the feed format. B must be the result set the delta produces,
+// from which standings are taken.
+func FromDelta(d liveo.ResultDelta, B liveo.ResultDataSet) Delta {
+	delta := Delta{
+		Version: Version,
+		Old:     FormatHash(d.Old),
+		New:     FormatHash(d.New),
+		Title:   d.Title,
+	}
+
+	if d.Courses != nil {
+		courses := &CoursesDelta{
+			Removed: sortedKeys(d.Courses.Removed),
+			Added:   []PlacedCourse{},
+		}
+		for _, j := range sortedKeys(d.Courses.Added) {
+			courses.Added = append(courses.Added, PlacedCourse{Index: j, Course: fromCourse(d.Courses.Added[j])})
+		}
+		for _, j := range sortedKeys(d.Courses.Modified) {
+			change := d.Courses.Modified[j]
+			courses.Modified = append(courses.Modified, CourseChange{
+				Index: j,
+				From:  change.From,
+				Title: change.Title,
+				Info:  change.Info,
+			})
+		}
+		delta.Courses = courses
+	}
+
+	if d.Competitors != nil {
+		for _, courseIndex := range sortedKeys(*d.Competitors) {
+			compDelta := (*d.Competitors)[courseIndex]
+			standings := []Standing{}
+			if courseIndex < len(B.Results.Courses) {
+				standings = Standings(B.Results.Courses[courseIndex])
+			}
+			competitors := CompetitorsDelta{
+				Course:    courseIndex,
+				Removed:   sortedKeys(compDelta.Removed),
+				Added:     []PlacedCompetitor{},
+				Standings: standings,
+			}
+			for _, j := range sortedKeys(compDelta.Added) {
+				competitor := fromCompetitor(compDelta.Added[j])
+				if j < len(standings) {
+					competitor.Standing = standings[j]
+				}
+				competitors.Added = append(competitors.Added, PlacedCompetitor{Index: j, Competitor: competitor})
+			}
+			for _, j := range sortedKeys(compDelta.Changed) {
+				change := fromCompetitorChange(j, compDelta.Changed[j])
+				if courseIndex < len(B.Results.Courses) && j < len(B.Results.Courses[courseIndex].Competitors) {
+					change.ID = B.Results.Courses[courseIndex].Competitors[j].Identity()
+				}
+				competitors.Changed = append(competitors.Changed, change)
+			}
+			delta.Competitors = append(delta.Competitors, competitors)
+		}
+	}
+
+	return delta
+}
+
+func fromCompetitorChange(index int, c liveo.CompetitorChange) CompetitorChange {
+	change := CompetitorChange{
+		Index:    index,
+		From:     c.From,
+		Name:     c.Name,
+		AgeClass: c.AgeClass,
+		Club:     c.Club,
+	}
+	if c.Time != nil {
+		ms := timeMs(*c.Time)
+		change.TimeMs = &ms
+	}
+	if c.Valid != nil {
+		s := status(*c.Valid)
+		change.Status = &s
+	}
+	return change
+}
+
+func sortedKeys[T any](m map[int]T) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,137 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// feedTestSet returns a result set of a single course with the given competitors
+func feedTestSet(info string, competitors ...liveo.Competitor) liveo.ResultDataSet {
+	r := liveo.Results{Title: "Event", Courses: []liveo.Course{{Title: "Brown", Info: info, Competitors: competitors}}}
+	return liveo.ResultDataSet{Results: r, Hash: liveo.HashResults(r)}
+}
+
+func TestFromResults(t *testing.T) {
+	rs := feedTestSet("4km 100m",
+		liveo.Competitor{Name: "Ann", Club: "SYO", Time: 41*time.Minute + 1500*time.Millisecond, Valid: true},
+		liveo.Competitor{ID: "2071", Name: "Bob", AgeClass: "M21", Time: 40 * time.Minute, Valid: true},
+		liveo.Competitor{Name: "Cat", Time: 30 * time.Minute, Valid: false},
+	)
+	rs.Hash = 1<<63 + 1 // beyond the integers a Javascript number holds exactly
+
+	got := FromResults(rs)
+	if got.Version != Version || got.Hash != "9223372036854775809" || got.Title != "Event" {
+		t.Errorf("results %d %q %q, want version %d and hash 9223372036854775809", got.Version, got.Hash, got.Title, Version)
+	}
+	if len(got.Courses) != 1 {
+		t.Fatalf("%d courses, want 1", len(got.Courses))
+	}
+	course := got.Courses[0]
+	if course.Title != "Brown" || course.Info != "4km 100m" || course.DistanceKm != 4 {
+		t.Errorf("course %q %q %v", course.Title, course.Info, course.DistanceKm)
+	}
+	want := []Competitor{
+		{
+			ID:       liveo.CompetitorFingerprint("Ann", "SYO"),
+			Standing: Standing{Position: 2, BehindMs: 61500, PercentBehind: 2.6, PaceMsPerKm: 615375},
+			Name:     "Ann",
+			Club:     "SYO",
+			TimeMs:   2461500,
+			Status:   StatusOK,
+		},
+		{
+			ID:       "2071",
+			Standing: Standing{Position: 1, PaceMsPerKm: 600000},
+			Name:     "Bob",
+			AgeClass: "M21",
+			TimeMs:   2400000,
+			Status:   StatusOK,
+		},
+		{
+			ID:     liveo.CompetitorFingerprint("Cat", ""),
+			Name:   "Cat",
+			TimeMs: 1800000,
+			Status: StatusInvalid,
+		},
+	}
+	if !reflect.DeepEqual(course.Competitors, want) {
+		t.Errorf("competitors\n%+v\nwant\n%+v", course.Competitors, want)
+	}
+}
+
+func TestFromDelta(t *testing.T) {
+	ann := liveo.Competitor{Name: "Ann", Time: 50 * time.Minute, Valid: true}
+	bob := liveo.Competitor{Name: "Bob", Time: 45 * time.Minute, Valid: true}
+	A := feedTestSet("", ann, bob)
+
+	annCorrected := ann
+	annCorrected.Time = 44 * time.Minute
+	cat := liveo.Competitor{Name: "Cat", Time: 40 * time.Minute, Valid: true}
+	B := feedTestSet("", annCorrected, bob, cat)
+
+	d := A.DeltaTo(B)
+	got := FromDelta(d, B)
+	if got.Version != Version || got.Old != FormatHash(A.Hash) || got.New != FormatHash(B.Hash) {
+		t.Errorf("delta %d from %q to %q", got.Version, got.Old, got.New)
+	}
+	if got.Courses != nil {
+		t.Errorf("course changes %+v, want none", got.Courses)
+	}
+	if len(got.Competitors) != 1 || got.Competitors[0].Course != 0 {
+		t.Fatalf("competitor changes %+v, want changes to course 0", got.Competitors)
+	}
+	competitors := got.Competitors[0]
+
+	// standings of every competitor are carried, since a finisher moves the others down
+	wantStandings := []Standing{
+		{Position: 2, BehindMs: 240000, PercentBehind: 10},
+		{Position: 3, BehindMs: 300000, PercentBehind: 12.5},
+		{Position: 1},
+	}
+	if !reflect.DeepEqual(competitors.Standings, wantStandings) {
+		t.Errorf("standings\n%+v\nwant\n%+v", competitors.Standings, wantStandings)
+	}
+
+	if len(competitors.Added) != 1 || competitors.Added[0].Index != 2 {
+		t.Fatalf("added %+v, want Cat at 2", competitors.Added)
+	}
+	if added := competitors.Added[0].Competitor; added.Name != "Cat" || added.TimeMs != 2400000 || added.Position != 1 || added.Status != StatusOK {
+		t.Errorf("added %+v", added)
+	}
+
+	if len(competitors.Changed) != 1 {
+		t.Fatalf("changed %+v, want Ann's time", competitors.Changed)
+	}
+	change := competitors.Changed[0]
+	if change.Index != 0 || change.From != 0 || change.ID != liveo.CompetitorFingerprint("Ann", "") {
+		t.Errorf("change %+v", change)
+	}
+	if change.TimeMs == nil || *change.TimeMs != 2640000 || change.Name != nil || change.Status != nil {
+		t.Errorf("change %+v, want only the time in ms", change)
+	}
+
+	// the delta applied to the results in the feed format gives the results in the feed format
+	if want := FromResults(B).Courses[0].Competitors[2]; !reflect.DeepEqual(competitors.Added[0].Competitor, want) {
+		t.Errorf("added\n%+v\nwant\n%+v", competitors.Added[0].Competitor, want)
+	}
+}
+
+func TestSchemaUpToDate(t *testing.T) {
+	schema, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := os.ReadFile("schema-v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(schema, '\n'), published) {
+		t.Error("schema-v1.json doesn't match the types of the feed; regenerate it with go generate ./feed")
+	}
+}
@@ -0,0 +1,23 @@
+// Command gen writes the JSON Schema of the viewer feed to schema-v1.json, for publishing to
+// third parties. Run it with go generate in the feed package.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/fivegreenapples/live-o-results/feed"
+)
+
+func main() {
+	schema, err := json.MarshalIndent(feed.Schema(), "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	file := fmt.Sprintf("schema-v%d.json", feed.Version)
+	if err := os.WriteFile(file, append(schema, '\n'), 0644); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,88 @@
+package feed
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Schema returns the JSON Schema of the format, generated from the types of this package. Field
+// descriptions and enumerations are taken from doc and enum struct tags.
+func Schema() map[string]interface{} {
+	defs := map[string]interface{}{}
+	results := schemaOf(reflect.TypeOf(Results{}), defs)
+	delta := schemaOf(reflect.TypeOf(Delta{}), defs)
+	event := schemaOf(reflect.TypeOf(Event{}), defs)
+	return map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "Live O Results viewer feed, version " + strconv.Itoa(Version),
+		"oneOf":   []interface{}{results, delta, event},
+		"$defs":   defs,
+	}
+}
+
+// schemaOf returns the schema of t, adding the definitions of any structs to defs
+func schemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), defs)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case reflect.Struct:
+		if _, found := defs[t.Name()]; !found {
+			defs[t.Name()] = nil // placeholder while the fields are described
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	panic("feed: no schema for " + t.String())
+}
+
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	addFields(t, defs, properties, &required)
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// addFields describes the fields of struct t, including those of embedded structs as
+// encoding/json flattens them
+func addFields(t reflect.Type, defs map[string]interface{}, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			addFields(field.Type, defs, properties, required)
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		name := tag[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		property := schemaOf(field.Type, defs)
+		if doc := field.Tag.Get("doc"); doc != "" {
+			property["description"] = doc
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			property["enum"] = strings.Split(enum, ",")
+		}
+		properties[name] = property
+
+		if len(tag) == 1 || tag[1] != "omitempty" {
+			*required = append(*required, name)
+		}
+	}
+}
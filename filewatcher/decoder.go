@@ -94,6 +94,8 @@ var xpCompetitorClub *xmlpath.Path
 var xpCompetitorAgeClass *xmlpath.Path
 var xpCompetitorTime *xmlpath.Path
 
+// decodeCompetitor decodes a row of a course's results. The row has no SI card or bib number so
+// the competitor is left without an ID, to be identified by name and club.
 func decodeCompetitor(cn *xmlpath.Node) (*liveo.Competitor, error) {
 
 	c := liveo.Competitor{}
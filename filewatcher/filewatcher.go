@@ -2,6 +2,7 @@ package main
 
 import (
 	"io/ioutil"
+	"net"
 	"reflect"
 	"time"
 
@@ -14,29 +15,43 @@ import (
 	"errors"
 
 	"github.com/fivegreenapples/live-o-results/liveo"
+	"github.com/fivegreenapples/live-o-results/pusher"
 	"github.com/fivegreenapples/throttledwatcher"
-	"github.com/mitchellh/hashstructure"
 )
 
 type fileWatcher struct {
 	controlCh chan interface{}
 	doneCh    chan struct{}
+	// statusChangedCh holds a pending publication of the status, so that servers whose status
+	// changes repeatedly cause it to be published once
+	statusChangedCh chan struct{}
 }
 type fileWatcherStatus struct {
-	File        string
-	ActiveWatch bool
-	Servers     []string
+	File         string
+	ActiveWatch  bool
+	Servers      []string
+	ServerStatus map[string]pusher.Status
 }
 type evGetStatus struct {
 	s chan fileWatcherStatus
 }
+type evGetResults struct {
+	rs chan liveo.ResultDataSet
+}
 type evRegisterStatusListener struct {
 	l      func(fileWatcherStatus)
 	result chan error
 }
 type evAddServer struct {
-	addr   string
-	result chan error
+	addr        string
+	tlsSettings *liveo.TLSSettings
+	result      chan error
+}
+type evAddSubscriber struct {
+	addr     string
+	conn     net.Conn
+	protocol liveo.Handshake
+	result   chan error
 }
 type evDropServer struct {
 	addr   string
@@ -51,12 +66,19 @@ type evStartFileWatch struct {
 type evStopFileWatch struct {
 	result chan error
 }
+
+// evNewResults carries results decoded from the file of the watch numbered watch
+type evNewResults struct {
+	watch int
+	rs    liveo.ResultDataSet
+}
 type evStop struct{}
 
 func newFileWatcher() *fileWatcher {
 	r := fileWatcher{
-		controlCh: make(chan interface{}),
-		doneCh:    make(chan struct{}),
+		controlCh:       make(chan interface{}),
+		doneCh:          make(chan struct{}),
+		statusChangedCh: make(chan struct{}, 1),
 	}
 	go r.run()
 	return &r
@@ -64,9 +86,11 @@ func newFileWatcher() *fileWatcher {
 
 func (r *fileWatcher) run() {
 
-	allServers := map[string]*managedServer{}
+	allServers := map[string]*pusher.Server{}
 	var watchedFile string
 	var fwStopper func()
+	// watchID numbers watches, so that results from a stopped watch can be ignored
+	var watchID int
 	var currentResultSet liveo.ResultDataSet
 
 	statusUpdates := make(chan fileWatcherStatus, 10)
@@ -85,21 +109,32 @@ func (r *fileWatcher) run() {
 
 	currentStatus := func() fileWatcherStatus {
 		s := fileWatcherStatus{
-			File:        watchedFile,
-			ActiveWatch: fwStopper != nil,
-			Servers:     make([]string, 0, len(allServers)),
+			File:         watchedFile,
+			ActiveWatch:  fwStopper != nil,
+			Servers:      make([]string, 0, len(allServers)),
+			ServerStatus: map[string]pusher.Status{},
 		}
-		for srv := range allServers {
+		for srv, ms := range allServers {
 			s.Servers = append(s.Servers, srv)
+			s.ServerStatus[srv] = ms.Status()
 		}
 		return s
 	}
 
 RANGELOOP:
-	for ev := range r.controlCh {
+	for {
+		var ev interface{}
+		select {
+		case <-r.statusChangedCh:
+			statusUpdates <- currentStatus()
+			continue
+		case ev = <-r.controlCh:
+		}
 		switch ev := ev.(type) {
 		case evGetStatus:
 			ev.s <- currentStatus()
+		case evGetResults:
+			ev.rs <- currentResultSet
 		case evRegisterStatusListener:
 			statusListenersMu.Lock()
 			statusListeners = append(statusListeners, ev.l)
@@ -110,7 +145,10 @@ RANGELOOP:
 				fwStopper()
 				fwStopper = nil
 			}
+			watchID++
+			watch := watchID
 			var err error
+			// the file is decoded on the watch's goroutine, and the results handed to this loop
 			fwStopper, err = startFileWatching(ev.file, ev.quietTime, func(f string) {
 				log.Println("watch event for", ev.file)
 				newResults, decodeErr := decodeResultsFile(strings.NewReader(f))
@@ -118,20 +156,10 @@ RANGELOOP:
 					log.Println("File decode error: ", decodeErr.Error())
 					return
 				}
-				hash, _ := hashstructure.Hash(newResults, nil)
-				newResultSet := liveo.ResultDataSet{
+				r.controlCh <- evNewResults{watch: watch, rs: liveo.ResultDataSet{
 					Results: *newResults,
-					Hash:    hash,
-				}
-				if reflect.DeepEqual(currentResultSet, newResultSet) {
-					// ignore results, file hasn't changed
-					log.Println("no change, ignoring")
-					return
-				}
-				currentResultSet = newResultSet
-				for _, s := range allServers {
-					s.submitResults(currentResultSet)
-				}
+					Hash:    liveo.HashResults(*newResults),
+				}}
 			})
 			watchedFile = ev.file
 			statusUpdates <- currentStatus()
@@ -139,6 +167,20 @@ RANGELOOP:
 				ev.result <- err
 			}
 			ev.result <- nil
+		case evNewResults:
+			if ev.watch != watchID || fwStopper == nil {
+				// the watch has since been stopped
+				continue
+			}
+			if reflect.DeepEqual(currentResultSet, ev.rs) {
+				// ignore results, file hasn't changed
+				log.Println("no change, ignoring")
+				continue
+			}
+			currentResultSet = ev.rs
+			for _, s := range allServers {
+				s.Submit(currentResultSet)
+			}
 		case evStopFileWatch:
 			if fwStopper != nil {
 				fwStopper()
@@ -152,20 +194,39 @@ RANGELOOP:
 				ev.result <- errors.New("result server is already subscribed")
 				continue
 			}
-			s := managedServer{
-				address: ev.addr,
-			}
-			if err := s.dial(); err != nil {
+			s := pusher.NewServer(ev.addr, ev.tlsSettings, r.statusChanged)
+			if err := s.Dial(); err != nil {
+				s.Close()
 				ev.result <- err
 				continue
 			}
 			if currentResultSet.Hash != 0 {
-				s.submitResults(currentResultSet)
+				s.Submit(currentResultSet)
 			}
-			allServers[ev.addr] = &s
+			allServers[ev.addr] = s
+			statusUpdates <- currentStatus()
+			ev.result <- nil
+		case evAddSubscriber:
+			_, found := allServers[ev.addr]
+			if found {
+				ev.result <- errors.New("result server is already subscribed")
+				continue
+			}
+			addr := ev.addr
+			// a connection failing at once is dropped after this event, once it's in allServers
+			s := pusher.NewSubscriber(addr, ev.conn, ev.protocol, r.statusChanged, func() {
+				go func() { r.controlCh <- evDropServer{addr: addr, result: make(chan error, 1)} }()
+			})
+			if currentResultSet.Hash != 0 {
+				s.Submit(currentResultSet)
+			}
+			allServers[addr] = s
 			statusUpdates <- currentStatus()
 			ev.result <- nil
 		case evDropServer:
+			if s, found := allServers[ev.addr]; found {
+				s.Close()
+			}
 			delete(allServers, ev.addr)
 			statusUpdates <- currentStatus()
 			ev.result <- nil
@@ -177,6 +238,16 @@ RANGELOOP:
 	r.doneCh <- struct{}{}
 }
 
+// statusChanged schedules publication of the status. It may be called from within the run loop
+// so mustn't block.
+func (r *fileWatcher) statusChanged() {
+	select {
+	case r.statusChangedCh <- struct{}{}:
+	default:
+		// a publication is already pending
+	}
+}
+
 func (r *fileWatcher) wait() {
 	<-r.doneCh
 }
@@ -190,6 +261,13 @@ func (r *fileWatcher) getStatus() fileWatcherStatus {
 	}
 	return <-sCh
 }
+func (r *fileWatcher) currentResults() liveo.ResultDataSet {
+	rsCh := make(chan liveo.ResultDataSet)
+	r.controlCh <- evGetResults{
+		rs: rsCh,
+	}
+	return <-rsCh
+}
 func (r *fileWatcher) registerStatusListener(l func(fileWatcherStatus)) {
 	resultCh := make(chan error)
 	r.controlCh <- evRegisterStatusListener{
@@ -198,11 +276,22 @@ func (r *fileWatcher) registerStatusListener(l func(fileWatcherStatus)) {
 	}
 	<-resultCh
 }
-func (r *fileWatcher) addResultsServer(address string) error {
+func (r *fileWatcher) addResultsServer(address string, tlsSettings *liveo.TLSSettings) error {
 	resultCh := make(chan error)
 	r.controlCh <- evAddServer{
-		addr:   address,
-		result: resultCh,
+		addr:        address,
+		tlsSettings: tlsSettings,
+		result:      resultCh,
+	}
+	return <-resultCh
+}
+func (r *fileWatcher) addSubscriber(address string, conn net.Conn, protocol liveo.Handshake) error {
+	resultCh := make(chan error)
+	r.controlCh <- evAddSubscriber{
+		addr:     address,
+		conn:     conn,
+		protocol: protocol,
+		result:   resultCh,
 	}
 	return <-resultCh
 }
@@ -237,8 +326,9 @@ func startFileWatching(file string, quietTime time.Duration, cb func(string)) (f
 	if err != nil {
 		return nil, err
 	}
+	// stopping closes stopCh rather than sending on it, as the goroutine may be waiting for cb
 	stopCh := make(chan struct{})
-	active := true
+	var stopOnce sync.Once
 	go func() {
 		for {
 			select {
@@ -251,15 +341,14 @@ func startFileWatching(file string, quietTime time.Duration, cb func(string)) (f
 				cb(string(fileContents))
 			case <-stopCh:
 				watcher.Stop()
-				active = false
 				return
 			}
 		}
 	}()
 	return func() {
-		if active {
+		stopOnce.Do(func() {
 			log.Println("Stopping watch on ", file)
-			stopCh <- struct{}{}
-		}
+			close(stopCh)
+		})
 	}, nil
 }
@@ -15,6 +15,9 @@ import (
 	"io/ioutil"
 
 	"fmt"
+
+	"github.com/fivegreenapples/live-o-results/export"
+	"github.com/fivegreenapples/live-o-results/liveo"
 )
 
 type fileWatcherManager struct {
@@ -40,6 +43,7 @@ func newFileWatcherManager(rw *fileWatcher) *fileWatcherManager {
 	http.HandleFunc("/filewatch/stop", rwm.demuxMap["filewatch.stop"].AllowPost())
 	http.HandleFunc("/resultsserver/add", rwm.demuxMap["resultsserver.add"].AllowPost())
 	http.HandleFunc("/resultsserver/remove", rwm.demuxMap["resultsserver.remove"].AllowPost())
+	http.Handle("/export/", export.Handler("/export/", rwm.rw.currentResults))
 
 	socketsHandler := sockjs.NewHandler("/sockjs", sockjs.DefaultOptions, func(session sockjs.Session) {
 
@@ -213,6 +217,7 @@ type StartFilewatchRequest struct {
 }
 type AddResultsServerRequest struct {
 	ServerAddress string
+	TLS           *liveo.TLSSettings
 }
 type RemoveResultsServerRequest struct {
 	ServerAddress string
@@ -237,7 +242,7 @@ func (rwm *fileWatcherManager) doStopFileWatch(interface{}) (interface{}, error)
 }
 func (rwm *fileWatcherManager) doAddResultsServer(req interface{}) (interface{}, error) {
 	request := req.(AddResultsServerRequest)
-	err := rwm.rw.addResultsServer(request.ServerAddress)
+	err := rwm.rw.addResultsServer(request.ServerAddress, request.TLS)
 	return nil, err
 }
 func (rwm *fileWatcherManager) doRemoveResultsServer(req interface{}) (interface{}, error) {
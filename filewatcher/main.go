@@ -1,15 +1,59 @@
 package main
 
 import (
+	"crypto/tls"
+	"flag"
 	"log"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
 )
 
 func main() {
+	tlsCA := flag.String("tls-ca", "", "CA bundle used to verify results servers, in place of the system roots. Enables TLS")
+	tlsCert := flag.String("tls-cert", "", "Client certificate file, for results servers requiring one. Enables TLS")
+	tlsKey := flag.String("tls-key", "", "Client certificate private key file")
+	subscriberInterface := flag.String("subscriber-interface", "", "Interface on which results servers which can't be reached from the venue may connect to pull results. Off unless given")
+	subscriberTLSCert := flag.String("subscriber-tls-cert", "", "TLS certificate file. Subscribers connect over TLS when given with -subscriber-tls-key")
+	subscriberTLSKey := flag.String("subscriber-tls-key", "", "TLS private key file for subscribers")
+	flag.Parse()
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatalln("Both -tls-cert and -tls-key are required to present a client certificate")
+	}
+	if (*subscriberTLSCert == "") != (*subscriberTLSKey == "") {
+		log.Fatalln("Both -subscriber-tls-cert and -subscriber-tls-key are required for subscribers to use TLS")
+	}
+
 	resultsFile := "/Users/ben/Documents/Orienteering/British Sprints/Live Results/index.html"
 	resultsServers := []string{"127.0.0.1:9000"}
 
+	// Without TLS flags, results servers are dialled with TLS only on port 443
+	var tlsSettings *liveo.TLSSettings
+	if *tlsCA != "" || *tlsCert != "" {
+		tlsSettings = &liveo.TLSSettings{
+			Enabled:  true,
+			CAFile:   *tlsCA,
+			CertFile: *tlsCert,
+			KeyFile:  *tlsKey,
+		}
+	}
+
 	rw := newFileWatcher()
 	newFileWatcherManager(rw)
+	if *subscriberInterface != "" {
+		var subscriberTLS *tls.Config
+		if *subscriberTLSCert != "" {
+			reloader, err := liveo.NewCertReloader(*subscriberTLSCert, *subscriberTLSKey)
+			if err != nil {
+				log.Fatalln("TLS configuration error:", err)
+			}
+			subscriberTLS = &tls.Config{GetCertificate: reloader.GetCertificate}
+		} else {
+			log.Println("Subscribers connect without TLS, so the shared secret is sent in the clear")
+		}
+		if err := rw.listenForSubscribers(*subscriberInterface, subscriberTLS); err != nil {
+			log.Fatalln("Couldn't listen for subscribers:", err)
+		}
+	}
 
 	watchErr := rw.startWatchingFile(resultsFile)
 	if watchErr != nil {
@@ -17,7 +61,7 @@ func main() {
 	}
 
 	for _, s := range resultsServers {
-		rsErr := rw.addResultsServer(s)
+		rsErr := rw.addResultsServer(s, tlsSettings)
 		if rsErr != nil {
 			log.Println(rsErr)
 		}
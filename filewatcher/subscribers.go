@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// listenForSubscribers accepts connections from results servers which pull results from us,
+// for when the results server can reach the filewatcher but not the other way round. Once
+// connected a subscriber is sent results exactly as if it had been added as a results server,
+// though it can't be re-dialled and is dropped when its connection fails. Subscribers send the
+// shared secret, so a tlsConfig should be given unless the network is trusted.
+func (r *fileWatcher) listenForSubscribers(addr string, tlsConfig *tls.Config) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(liveo.SubscribeEndpoint, func(w http.ResponseWriter, req *http.Request) {
+		conn, protocol, ok := liveo.AcceptRPC(w, req)
+		if !ok {
+			return
+		}
+		log.Printf("Results server subscribed from %s using protocol v%d %v", req.RemoteAddr, protocol.Version, protocol.Capabilities)
+		if err := r.addSubscriber("subscriber "+req.RemoteAddr, conn, protocol); err != nil {
+			log.Println("Couldn't add subscriber:", err)
+			conn.Close()
+		}
+	})
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	srv := &http.Server{
+		Handler:     mux,
+		ReadTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := srv.Serve(ln)
+		log.Println("Stopped listening for subscribers:", err)
+	}()
+	return nil
+}
@@ -0,0 +1,119 @@
+package lcs
+
+/**
+ * Myers' O(ND) difference algorithm
+ * @see http://www.xmailserver.org/diff2.pdf
+ */
+
+// Op is the operation performed by an Edit
+type Op int
+
+// Edit operations
+const (
+	// Keep an element common to both sequences
+	Keep Op = iota
+	// Insert an element of B
+	Insert
+	// Delete an element of A
+	Delete
+)
+
+// An Edit is one step of an edit script turning A into B. A and B are the indices of the element
+// in each sequence, with B being -1 for a Delete and A being -1 for an Insert.
+type Edit struct {
+	Op Op
+	A  int
+	B  int
+}
+
+// Diff returns the shortest edit script turning A into B. Edits are in order of position in both
+// A and B, and the Keep edits together form a longest common subsequence.
+func Diff[T comparable](A, B []T) []Edit {
+	startIndex := findStartIndex(A, B)
+	endIndexA, endIndexB := findEndIndices(A, B, startIndex)
+
+	edits := make([]Edit, 0, len(A)+len(B)-startIndex)
+	for i := 0; i < startIndex; i++ {
+		edits = append(edits, Edit{Keep, i, i})
+	}
+	edits = append(edits, doMyers(A[startIndex:endIndexA], B[startIndex:endIndexB], startIndex)...)
+	for iA, iB := endIndexA, endIndexB; iA < len(A); iA, iB = iA+1, iB+1 {
+		edits = append(edits, Edit{Keep, iA, iB})
+	}
+	return edits
+}
+
+// doMyers diffs A and B, offsetting the indices in the resulting edits by offset
+func doMyers[T comparable](A, B []T, offset int) []Edit {
+	n, m := len(A), len(B)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	// v[k+max] holds the furthest x reached on diagonal k. trace records v before each round
+	// so the path can be recovered afterwards.
+	v := make([]int, 2*max+1)
+	trace := [][]int{}
+
+SEARCH:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+max] < v[k+1+max]) {
+				x = v[k+1+max] // down, i.e. insertion
+			} else {
+				x = v[k-1+max] + 1 // right, i.e. deletion
+			}
+			y := x - k
+			for x < n && y < m && A[x] == B[y] {
+				x++
+				y++
+			}
+			v[k+max] = x
+			if x >= n && y >= m {
+				break SEARCH
+			}
+		}
+	}
+
+	// Walk back from the end through each round, collecting edits in reverse
+	reversed := make([]Edit, 0, max)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[k-1+max] < v[k+1+max]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = v[prevK+max]
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY && x > 0 && y > 0 {
+			x--
+			y--
+			reversed = append(reversed, Edit{Keep, x + offset, y + offset})
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, Edit{Insert, -1, prevY + offset})
+			} else {
+				reversed = append(reversed, Edit{Delete, prevX + offset, -1})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	edits := make([]Edit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
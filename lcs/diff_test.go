@@ -0,0 +1,132 @@
+package lcs
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	keep := func(a, b int) Edit { return Edit{Keep, a, b} }
+	ins := func(b int) Edit { return Edit{Insert, -1, b} }
+	del := func(a int) Edit { return Edit{Delete, a, -1} }
+
+	tests := []struct {
+		name string
+		A, B string
+		want []Edit
+	}{
+		{"both empty", "", "", nil},
+		{"all removed", "abc", "", []Edit{del(0), del(1), del(2)}},
+		{"all added", "", "abc", []Edit{ins(0), ins(1), ins(2)}},
+		{"unchanged", "abc", "abc", []Edit{keep(0, 0), keep(1, 1), keep(2, 2)}},
+		{"interleaved", "abcd", "axcy", []Edit{keep(0, 0), del(1), ins(1), keep(2, 2), del(3), ins(3)}},
+		{"duplicate removed", "aab", "ab", []Edit{keep(0, 0), del(1), keep(2, 1)}},
+		{"duplicate added", "ab", "aab", []Edit{keep(0, 0), ins(1), keep(1, 2)}},
+		{"duplicates appended", "aa", "aaa", []Edit{keep(0, 0), keep(1, 1), ins(2)}},
+		{"rotated", "abab", "baba", []Edit{del(0), keep(1, 0), keep(2, 1), keep(3, 2), ins(3)}},
+		{"myers paper example", "abcabba", "cbabac", []Edit{
+			del(0), del(1), keep(2, 0), ins(1), keep(3, 2), keep(4, 3), del(5), keep(6, 4), ins(5),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			A, B := strings.Split(tt.A, ""), strings.Split(tt.B, "")
+			got := Diff(A, B)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff(%q, %q) = %v, want %v", tt.A, tt.B, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiffScript checks that edit scripts turn A into B, keeping a longest common subsequence
+func TestDiffScript(t *testing.T) {
+	pairs := [][2]string{
+		{"abcabba", "cbabac"}, {"xaxbxcx", "abc"}, {"aaaa", "bbbb"}, {"abcdefgh", "hgfedcba"},
+		{"thequickbrownfox", "thequackbrownfix"}, {"aabbaabb", "ababab"},
+	}
+	for _, pair := range pairs {
+		A, B := strings.Split(pair[0], ""), strings.Split(pair[1], "")
+		var built []string
+		keeps := 0
+		nextA, nextB := 0, 0
+		for _, e := range Diff(A, B) {
+			switch e.Op {
+			case Keep:
+				if e.A != nextA || e.B != nextB || A[e.A] != B[e.B] {
+					t.Fatalf("%q to %q: bad keep %v", pair[0], pair[1], e)
+				}
+				built = append(built, A[e.A])
+				keeps++
+				nextA, nextB = nextA+1, nextB+1
+			case Insert:
+				if e.B != nextB {
+					t.Fatalf("%q to %q: insert %v out of order", pair[0], pair[1], e)
+				}
+				built = append(built, B[e.B])
+				nextB++
+			case Delete:
+				if e.A != nextA {
+					t.Fatalf("%q to %q: delete %v out of order", pair[0], pair[1], e)
+				}
+				nextA++
+			}
+		}
+		if strings.Join(built, "") != pair[1] || nextA != len(A) {
+			t.Errorf("%q to %q: script built %q", pair[0], pair[1], strings.Join(built, ""))
+		}
+		if want := len(Calculate(A, B)); keeps != want {
+			t.Errorf("%q to %q: script keeps %d, longest common subsequence is %d", pair[0], pair[1], keeps, want)
+		}
+	}
+}
+
+// benchmarkSizes are the lengths of course benchmarked, the larger ones being big courses at
+// major events
+var benchmarkSizes = []int{300, 1000}
+
+// benchmarkLists returns a course of n competitor keys and the same course after a few finishers
+// were added and a few results corrected
+func benchmarkLists(n int) (A, B []string) {
+	r := rand.New(rand.NewSource(int64(n)))
+	for i := 0; i < n; i++ {
+		A = append(A, fmt.Sprint("runner ", i))
+	}
+	B = append(B, A...)
+	for i := 0; i < n/20; i++ {
+		at := r.Intn(len(B))
+		B = append(B[:at:at], append([]string{fmt.Sprint("finisher ", i)}, B[at:]...)...)
+		B[r.Intn(len(B))] += " corrected"
+	}
+	return A, B
+}
+
+func BenchmarkCalculate(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		A, B := benchmarkLists(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Calculate(A, B)
+			}
+		})
+	}
+}
+
+func BenchmarkDiff(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		A, B := benchmarkLists(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Diff(A, B)
+			}
+		})
+	}
+}
@@ -5,10 +5,10 @@ package lcs
  * @see https://en.wikipedia.org/wiki/Longest_common_subsequence_problem
  */
 
-// Calculate returns the longest common subsequence of two given string slices
-func Calculate(A, B []string) []string {
+// Calculate returns the longest common subsequence of two given slices
+func Calculate[T comparable](A, B []T) []T {
 	if len(A) == 0 || len(B) == 0 {
-		return []string{}
+		return []T{}
 	}
 
 	startIndex := findStartIndex(A, B)
@@ -23,7 +23,7 @@ func Calculate(A, B []string) []string {
 
 	partialLCS := doLCS(A[startIndex:endIndexA], B[startIndex:endIndexB])
 
-	lcs := make([]string, startIndex)
+	lcs := make([]T, startIndex)
 	copy(lcs, A[:startIndex])
 	lcs = append(lcs, partialLCS...)
 	lcs = append(lcs, A[endIndexA:]...)
@@ -31,7 +31,7 @@ func Calculate(A, B []string) []string {
 
 }
 
-func findStartIndex(A, B []string) int {
+func findStartIndex[T comparable](A, B []T) int {
 	start, i, j := 0, 0, 0
 	for i < len(A) && j < len(B) && A[i] == B[j] {
 		start = i + 1
@@ -41,7 +41,7 @@ func findStartIndex(A, B []string) int {
 	return start
 }
 
-func findEndIndices(A, B []string, startIndex int) (int, int) {
+func findEndIndices[T comparable](A, B []T, startIndex int) (int, int) {
 	endA, endB := len(A), len(B)
 	for endA > startIndex && endB > startIndex && endA > 0 && endB > 0 && A[endA-1] == B[endB-1] {
 		endA--
@@ -50,17 +50,19 @@ func findEndIndices(A, B []string, startIndex int) (int, int) {
 	return endA, endB
 }
 
-func doLCS(A, B []string) []string {
+func doLCS[T comparable](A, B []T) []T {
 
 	partialsLength := len(B) + 1
-	partials := make([][]string, partialsLength)
+	partials := make([][]T, partialsLength)
 
 	for _, elA := range A {
-		newPartials := make([][]string, partialsLength)
+		newPartials := make([][]T, partialsLength)
 		for iB, elB := range B {
 			if elA == elB {
-				newPartials[iB+1] = partials[iB]
-				newPartials[iB+1] = append(newPartials[iB+1], elA)
+				// cap the partial so append copies rather than writing into a backing
+				// array shared with other partials
+				partial := partials[iB]
+				newPartials[iB+1] = append(partial[:len(partial):len(partial)], elA)
 			} else {
 				if len(partials[iB+1]) > len(newPartials[iB]) {
 					newPartials[iB+1] = partials[iB+1]
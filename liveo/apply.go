@@ -0,0 +1,157 @@
+package liveo
+
+import "fmt"
+
+// Apply returns the result of applying delta d to A, such that A.Apply(A.DeltaTo(B)) gives B.
+// The delta must have been produced from A, and its indices must be in range for the lists they
+// modify. The hash of the patched results is verified against d.New, unless that is zero which
+// indicates a producer that doesn't compute hashes. A is left unmodified.
+func (A ResultDataSet) Apply(d ResultDelta) (ResultDataSet, error) {
+	if A.Hash != d.Old {
+		return ResultDataSet{}, fmt.Errorf("delta from unknown base %d, current hash is %d", d.Old, A.Hash)
+	}
+
+	B := A.Clone()
+	if d.Title != nil {
+		B.Results.Title = *d.Title
+	}
+
+	if d.Courses != nil {
+		added, err := resolveChanges(B.Results.Courses, d.Courses.Removed, d.Courses.Added, d.Courses.Modified)
+		if err != nil {
+			return ResultDataSet{}, fmt.Errorf("courses: %s", err)
+		}
+		courses, err := applyListDelta(B.Results.Courses, d.Courses.Removed, added)
+		if err != nil {
+			return ResultDataSet{}, fmt.Errorf("courses: %s", err)
+		}
+		B.Results.Courses = courses
+	}
+
+	if d.Competitors != nil {
+		for courseIndex, compDelta := range *d.Competitors {
+			if courseIndex < 0 || courseIndex >= len(B.Results.Courses) {
+				return ResultDataSet{}, fmt.Errorf("competitors: course index %d out of range", courseIndex)
+			}
+			course := &B.Results.Courses[courseIndex]
+			competitors, err := applyCompetitorsDelta(course.Competitors, compDelta)
+			if err != nil {
+				return ResultDataSet{}, fmt.Errorf("competitors of course %d: %s", courseIndex, err)
+			}
+			course.Competitors = competitors
+		}
+	}
+
+	B.Hash = HashResults(B.Results)
+	if d.New != 0 && B.Hash != d.New {
+		return ResultDataSet{}, fmt.Errorf("patched results didn't match expected hash. Expected %d vs calculated %d", d.New, B.Hash)
+	}
+	return B, nil
+}
+
+// applyCompetitorsDelta returns the competitors A with d applied
+func applyCompetitorsDelta(A []Competitor, d CompetitorsDelta) ([]Competitor, error) {
+	added, err := resolveChanges(A, d.Removed, d.Added, d.Changed)
+	if err != nil {
+		return nil, err
+	}
+	return applyListDelta(A, d.Removed, added)
+}
+
+// applyListDelta removes the items of A at the indices in removed, then places the items of added
+// at their indices in the resulting list, with the remaining items of A filling the gaps in order.
+func applyListDelta[T any](A []T, removed map[int]int, added map[int]T) ([]T, error) {
+	for i := range removed {
+		if i < 0 || i >= len(A) {
+			return nil, fmt.Errorf("removed index %d out of range", i)
+		}
+	}
+	newLength := len(A) - len(removed) + len(added)
+	for i := range added {
+		if i < 0 || i >= newLength {
+			return nil, fmt.Errorf("added index %d out of range", i)
+		}
+	}
+
+	B := make([]T, 0, newLength)
+	cursorA := 0
+	for len(B) < newLength {
+		if item, found := added[len(B)]; found {
+			B = append(B, item)
+			continue
+		}
+		for _, skip := removed[cursorA]; skip; _, skip = removed[cursorA] {
+			cursorA++
+		}
+		B = append(B, A[cursorA])
+		cursorA++
+	}
+	return B, nil
+}
+
+// itemChange is a change to an item of a list, such as a CompetitorChange
+type itemChange[T any] interface {
+	from() int
+	applyTo(T) T
+}
+
+// resolveChanges returns the items to be placed by a list delta, being those added plus those
+// changed, which are taken from A and updated.
+func resolveChanges[T any, C itemChange[T]](A []T, removed map[int]int, added map[int]T, changed map[int]C) (map[int]T, error) {
+	if len(changed) == 0 {
+		return added, nil
+	}
+	placed := make(map[int]T, len(added)+len(changed))
+	for j, item := range added {
+		placed[j] = item
+	}
+	fromSeen := map[int]bool{}
+	for j, change := range changed {
+		from := change.from()
+		if _, found := removed[from]; !found || from < 0 || from >= len(A) {
+			return nil, fmt.Errorf("changed item %d not removed from index %d", j, from)
+		}
+		if fromSeen[from] {
+			return nil, fmt.Errorf("item %d changed more than once", from)
+		}
+		if _, found := placed[j]; found {
+			return nil, fmt.Errorf("index %d both added and changed", j)
+		}
+		fromSeen[from] = true
+		placed[j] = change.applyTo(A[from])
+	}
+	return placed, nil
+}
+
+// CourseSources maps the index of each course in the results d produces from A to the index in
+// A of the course it came from, for courses d keeps or modifies
+func (d ResultDelta) CourseSources(A ResultDataSet) map[int]int {
+	sources := map[int]int{}
+	if d.Courses == nil {
+		for i := range A.Results.Courses {
+			sources[i] = i
+		}
+		return sources
+	}
+	numCourses := len(A.Results.Courses)
+	newLength := numCourses - len(d.Courses.Removed) + len(d.Courses.Added) + len(d.Courses.Modified)
+	cursorA := 0
+	for j := 0; j < newLength; j++ {
+		if _, found := d.Courses.Added[j]; found {
+			continue
+		}
+		if change, found := d.Courses.Modified[j]; found {
+			sources[j] = change.From
+			continue
+		}
+		for _, skip := d.Courses.Removed[cursorA]; skip; _, skip = d.Courses.Removed[cursorA] {
+			cursorA++
+		}
+		if cursorA >= numCourses {
+			break
+		}
+		sources[j] = cursorA
+		cursorA++
+	}
+	return sources
+}
@@ -0,0 +1,161 @@
+package liveo
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestApplyDeltaTo checks that A.Apply(A.DeltaTo(B)) gives B for random pairs of result sets,
+// where B is A with courses and competitors renamed, reordered, duplicated, corrected, removed
+// and added. The deltas are also applied as sent to peers without change capabilities.
+func TestApplyDeltaTo(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	legacy := Handshake{Version: LegacyProtocolVersion, Capabilities: LegacyCapabilities}
+	for i := 0; i < 2000; i++ {
+		A := randomResultSet(r)
+		B := mutateResultSet(r, A)
+
+		delta := A.DeltaTo(B)
+		got, err := A.Apply(delta)
+		if err != nil {
+			t.Fatalf("case %d: %s\nA: %+v\nB: %+v\ndelta: %+v", i, err, A, B, delta)
+		}
+		if !sameResults(got.Results, B.Results) || got.Hash != B.Hash {
+			t.Fatalf("case %d: applying the delta gave\n%+v\nwant\n%+v", i, got, B)
+		}
+
+		got, err = A.Apply(delta.ExpandChanges(A, legacy))
+		if err != nil {
+			t.Fatalf("case %d: expanded delta: %s", i, err)
+		}
+		if !sameResults(got.Results, B.Results) {
+			t.Fatalf("case %d: applying the expanded delta gave\n%+v\nwant\n%+v", i, got, B)
+		}
+	}
+}
+
+func TestApplyRejectsUnknownBase(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	A := randomResultSet(r)
+	B := mutateResultSet(r, A)
+	delta := A.DeltaTo(B)
+	delta.Old++
+	if _, err := A.Apply(delta); err == nil {
+		t.Error("a delta from another base was applied")
+	}
+}
+
+func randomResultSet(r *rand.Rand) ResultDataSet {
+	results := Results{Title: "Event"}
+	for i, n := 0, r.Intn(6); i < n; i++ {
+		results.Courses = append(results.Courses, randomCourse(r))
+	}
+	return ResultDataSet{Results: results, Hash: HashResults(results)}
+}
+
+func randomCourse(r *rand.Rand) Course {
+	c := Course{Title: fmt.Sprint("Course ", r.Intn(8)), Info: fmt.Sprintf("%d.%dkm", 2+r.Intn(6), r.Intn(10))}
+	if r.Intn(2) == 0 {
+		c.ID = fmt.Sprint("id", r.Intn(8))
+	}
+	for i, n := 0, r.Intn(8); i < n; i++ {
+		c.Competitors = append(c.Competitors, randomCompetitor(r))
+	}
+	return c
+}
+
+func randomCompetitor(r *rand.Rand) Competitor {
+	c := Competitor{
+		Name:     fmt.Sprint("Runner ", r.Intn(12)),
+		AgeClass: fmt.Sprint("M", 10+5*r.Intn(8)),
+		Club:     fmt.Sprint("Club ", r.Intn(3)),
+		Time:     time.Duration(600+r.Intn(3000)) * time.Second,
+		Valid:    r.Intn(5) > 0,
+	}
+	if r.Intn(3) == 0 {
+		c.ID = fmt.Sprint(100000 + r.Intn(12))
+	}
+	return c
+}
+
+// mutateResultSet returns a copy of A with random changes
+func mutateResultSet(r *rand.Rand, A ResultDataSet) ResultDataSet {
+	B := A.Clone().Results
+	if r.Intn(10) == 0 {
+		B.Title = "Renamed event"
+	}
+	for i, n := 0, r.Intn(5); i < n; i++ {
+		courses := B.Courses
+		switch op := r.Intn(7); {
+		case op == 0 || len(courses) == 0:
+			at := r.Intn(len(courses) + 1)
+			B.Courses = append(courses[:at:at], append([]Course{randomCourse(r)}, courses[at:]...)...)
+		case op == 1:
+			at := r.Intn(len(courses))
+			B.Courses = append(courses[:at:at], courses[at+1:]...)
+		case op == 2:
+			a, b := r.Intn(len(courses)), r.Intn(len(courses))
+			courses[a], courses[b] = courses[b], courses[a]
+		case op == 3:
+			at := r.Intn(len(courses))
+			duplicate := courses[at]
+			duplicate.Competitors = append([]Competitor(nil), duplicate.Competitors...)
+			B.Courses = append(courses, duplicate)
+		case op == 4:
+			courses[r.Intn(len(courses))].Title = fmt.Sprint("Renamed ", r.Intn(3))
+		case op == 5:
+			courses[r.Intn(len(courses))].Info = "corrected"
+		default:
+			c := &courses[r.Intn(len(courses))]
+			c.Competitors = mutateCompetitors(r, c.Competitors)
+		}
+	}
+	return ResultDataSet{Results: B, Hash: HashResults(B)}
+}
+
+func mutateCompetitors(r *rand.Rand, A []Competitor) []Competitor {
+	B := append([]Competitor(nil), A...)
+	for i, n := 0, 1+r.Intn(4); i < n; i++ {
+		switch op := r.Intn(6); {
+		case op == 0 || len(B) == 0:
+			at := r.Intn(len(B) + 1)
+			B = append(B[:at:at], append([]Competitor{randomCompetitor(r)}, B[at:]...)...)
+		case op == 1:
+			at := r.Intn(len(B))
+			B = append(B[:at:at], B[at+1:]...)
+		case op == 2:
+			a, b := r.Intn(len(B)), r.Intn(len(B))
+			B[a], B[b] = B[b], B[a]
+		case op == 3:
+			B = append(B, B[r.Intn(len(B))])
+		case op == 4:
+			B[r.Intn(len(B))].Time += time.Duration(1+r.Intn(60)) * time.Second
+		default:
+			c := &B[r.Intn(len(B))]
+			c.Valid = !c.Valid
+			c.AgeClass = "W21"
+		}
+	}
+	return B
+}
+
+// sameResults compares results, including the IDs the hash ignores, treating nil and empty lists
+// as equal
+func sameResults(a, b Results) bool {
+	normalise := func(r Results) Results {
+		r = ResultDataSet{Results: r}.Clone().Results
+		for i := range r.Courses {
+			if len(r.Courses[i].Competitors) == 0 {
+				r.Courses[i].Competitors = nil
+			}
+		}
+		if len(r.Courses) == 0 {
+			r.Courses = nil
+		}
+		return r
+	}
+	return reflect.DeepEqual(normalise(a), normalise(b))
+}
@@ -0,0 +1,120 @@
+package liveo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// CompetitorFingerprint identifies a runner by name and club, for when the results give no
+// better identifier. Case and surrounding space are ignored.
+func CompetitorFingerprint(name, club string) string {
+	normalised := strings.ToLower(strings.TrimSpace(name)) + "|" + strings.ToLower(strings.TrimSpace(club))
+	sum := sha256.Sum256([]byte(normalised))
+	return "fp:" + hex.EncodeToString(sum[:8])
+}
+
+// Identity returns the competitor's ID, or its fingerprint if it has none
+func (c Competitor) Identity() string {
+	if c.ID != "" {
+		return c.ID
+	}
+	return CompetitorFingerprint(c.Name, c.Club)
+}
+
+// A CompetitorChange describes a correction to a competitor. The corrected competitor is the one
+// at index From in the old list, with any non-nil fields replaced.
+type CompetitorChange struct {
+	From     int
+	Name     *string        `json:",omitempty"`
+	AgeClass *string        `json:",omitempty"`
+	Club     *string        `json:",omitempty"`
+	Time     *time.Duration `json:",omitempty"`
+	Valid    *bool          `json:",omitempty"`
+}
+
+// changeBetween returns the change turning competitor a, found at index from, into b
+func changeBetween(from int, a, b Competitor) CompetitorChange {
+	change := CompetitorChange{From: from}
+	if a.Name != b.Name {
+		change.Name = &b.Name
+	}
+	if a.AgeClass != b.AgeClass {
+		change.AgeClass = &b.AgeClass
+	}
+	if a.Club != b.Club {
+		change.Club = &b.Club
+	}
+	if a.Time != b.Time {
+		change.Time = &b.Time
+	}
+	if a.Valid != b.Valid {
+		change.Valid = &b.Valid
+	}
+	return change
+}
+
+// applyTo returns competitor c with the change made
+func (change CompetitorChange) applyTo(c Competitor) Competitor {
+	if change.Name != nil {
+		c.Name = *change.Name
+	}
+	if change.AgeClass != nil {
+		c.AgeClass = *change.AgeClass
+	}
+	if change.Club != nil {
+		c.Club = *change.Club
+	}
+	if change.Time != nil {
+		c.Time = *change.Time
+	}
+	if change.Valid != nil {
+		c.Valid = *change.Valid
+	}
+	return c
+}
+
+// findChanges pairs competitors removed from A with competitors added having the same identity,
+// which are corrections rather than new finishers. Paired competitors are moved from added into
+// the returned changes.
+func findChanges(A []Competitor, removed map[int]int, added map[int]Competitor) map[int]CompetitorChange {
+	var changed map[int]CompetitorChange
+	for j, from := range pairByIdentity(A, removed, added, Competitor.Identity) {
+		if changed == nil {
+			changed = map[int]CompetitorChange{}
+		}
+		changed[j] = changeBetween(from, A[from], added[j])
+		delete(added, j)
+	}
+	return changed
+}
+
+func (change CompetitorChange) from() int {
+	return change.From
+}
+
+func (change CompetitorChange) withFrom(from int) CompetitorChange {
+	change.From = from
+	return change
+}
+
+// then returns the change made by this change followed by later
+func (change CompetitorChange) then(later CompetitorChange) CompetitorChange {
+	if later.Name != nil {
+		change.Name = later.Name
+	}
+	if later.AgeClass != nil {
+		change.AgeClass = later.AgeClass
+	}
+	if later.Club != nil {
+		change.Club = later.Club
+	}
+	if later.Time != nil {
+		change.Time = later.Time
+	}
+	if later.Valid != nil {
+		change.Valid = later.Valid
+	}
+	return change
+}
@@ -0,0 +1,235 @@
+package liveo
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Compose returns a single delta equivalent to applying d and then e, so that A.Apply(d.Compose(e))
+// gives the same results as A.Apply(d) followed by Apply(e). e must follow on from d.
+func (d ResultDelta) Compose(e ResultDelta) (ResultDelta, error) {
+	if d.New != e.Old {
+		return ResultDelta{}, fmt.Errorf("delta from %d doesn't follow delta to %d", e.Old, d.New)
+	}
+
+	composed := ResultDelta{
+		Old:   d.Old,
+		New:   e.New,
+		Title: d.Title,
+	}
+	if e.Title != nil {
+		composed.Title = e.Title
+	}
+
+	first, second := coursesOf(d), coursesOf(e)
+	competitorsFirst, competitorsSecond := competitorsOf(d), competitorsOf(e)
+
+	// Courses added by d are given in full, so d's changes to their competitors are folded in
+	for j, course := range first.added {
+		compDelta, found := competitorsFirst[j]
+		if !found {
+			continue
+		}
+		competitors, err := applyCompetitorsDelta(course.Competitors, compDelta)
+		if err != nil {
+			return ResultDelta{}, fmt.Errorf("competitors of course %d: %s", j, err)
+		}
+		course.Competitors = competitors
+		first.added[j] = course
+		delete(competitorsFirst, j)
+	}
+
+	courses, steps := composeList(first, second)
+
+	competitors := map[int]CompetitorsDelta{}
+	for j, compDelta := range competitorsFirst {
+		if l, found := steps.toC(j); found {
+			competitors[l] = compDelta
+		}
+	}
+	for l, compDelta := range competitorsSecond {
+		if course, found := courses.added[l]; found {
+			// likewise for courses given in full by the composed delta
+			competitors, err := applyCompetitorsDelta(course.Competitors, compDelta)
+			if err != nil {
+				return ResultDelta{}, fmt.Errorf("competitors of course %d: %s", l, err)
+			}
+			course.Competitors = competitors
+			courses.added[l] = course
+			continue
+		}
+		composedComp, _ := composeList(competitorList(competitors[l]), competitorList(compDelta))
+		competitors[l] = CompetitorsDelta{
+			Removed: composedComp.removed,
+			Added:   composedComp.added,
+			Changed: composedComp.changed,
+		}
+	}
+
+	if !courses.empty() {
+		composed.Courses = &CoursesDelta{
+			Removed:  courses.removed,
+			Added:    courses.added,
+			Modified: courses.changed,
+		}
+	}
+	for l, compDelta := range competitors {
+		if competitorList(compDelta).empty() {
+			delete(competitors, l)
+		}
+	}
+	if len(competitors) > 0 {
+		composed.Competitors = &competitors
+	}
+	return composed, nil
+}
+
+// listDelta is the removals, additions and changes made to a list by a delta
+type listDelta[T, C any] struct {
+	removed map[int]int
+	added   map[int]T
+	changed map[int]C
+}
+
+func (d listDelta[T, C]) empty() bool {
+	return len(d.removed) == 0 && len(d.added) == 0 && len(d.changed) == 0
+}
+
+// listChange is an itemChange that can be combined with a later change to the same item
+type listChange[T, C any] interface {
+	itemChange[T]
+	then(C) C
+	withFrom(int) C
+}
+
+// coursesOf returns a copy of the course changes made by d
+func coursesOf(d ResultDelta) listDelta[Course, CourseChange] {
+	courses := listDelta[Course, CourseChange]{map[int]int{}, map[int]Course{}, map[int]CourseChange{}}
+	if d.Courses != nil {
+		for i := range d.Courses.Removed {
+			courses.removed[i] = 0
+		}
+		for j, c := range d.Courses.Added {
+			courses.added[j] = c
+		}
+		for j, change := range d.Courses.Modified {
+			courses.changed[j] = change
+		}
+	}
+	return courses
+}
+
+// competitorsOf returns a copy of the competitor changes made by d
+func competitorsOf(d ResultDelta) map[int]CompetitorsDelta {
+	competitors := map[int]CompetitorsDelta{}
+	if d.Competitors != nil {
+		for j, compDelta := range *d.Competitors {
+			competitors[j] = compDelta
+		}
+	}
+	return competitors
+}
+
+func competitorList(d CompetitorsDelta) listDelta[Competitor, CompetitorChange] {
+	return listDelta[Competitor, CompetitorChange]{d.Removed, d.Added, d.Changed}
+}
+
+// composeList composes two deltas of a list, the first turning A into B and the second B into C.
+// The returned steps relate indices in B to those in A and C.
+func composeList[T any, C listChange[T, C]](first, second listDelta[T, C]) (listDelta[T, C], listSteps) {
+	steps := listSteps{
+		removed1:     sortedKeys(first.removed),
+		placed1:      append(sortedKeys(first.added), sortedKeys(first.changed)...),
+		removed2:     sortedKeys(second.removed),
+		placed2:      append(sortedKeys(second.added), sortedKeys(second.changed)...),
+		changedFrom2: map[int]int{},
+	}
+	sort.Ints(steps.placed1)
+	sort.Ints(steps.placed2)
+	for l, change := range second.changed {
+		steps.changedFrom2[change.from()] = l
+	}
+
+	composed := listDelta[T, C]{map[int]int{}, map[int]T{}, map[int]C{}}
+	for i := range first.removed {
+		composed.removed[i] = 0
+	}
+	// Items of A kept by the first delta but removed by the second
+	for j := range second.removed {
+		if !steps.placedInB(j) {
+			composed.removed[steps.toA(j)] = 0
+		}
+	}
+
+	// Items placed by the first delta and kept by the second. Those the second changes are
+	// replaced below.
+	for j, item := range first.added {
+		if l, found := steps.toC(j); found {
+			composed.added[l] = item
+		}
+	}
+	for j, change := range first.changed {
+		if l, found := steps.toC(j); found {
+			composed.changed[l] = change
+		}
+	}
+
+	// Items placed by the second delta, where changes apply to whatever the first left at From
+	for l, item := range second.added {
+		composed.added[l] = item
+	}
+	for l, change := range second.changed {
+		j := change.from()
+		if item, found := first.added[j]; found {
+			composed.added[l] = change.applyTo(item)
+		} else if earlier, found := first.changed[j]; found {
+			composed.changed[l] = earlier.then(change)
+		} else {
+			composed.changed[l] = change.withFrom(steps.toA(j))
+		}
+	}
+
+	return composed, steps
+}
+
+// listSteps relates indices of lists A, B and C when composing a delta turning A into B with one
+// turning B into C. Index sets are sorted.
+type listSteps struct {
+	removed1, placed1 []int // removed from A and placed in B by the first delta
+	removed2, placed2 []int // removed from B and placed in C by the second delta
+	changedFrom2      map[int]int
+}
+
+func (s listSteps) placedInB(j int) bool {
+	i := sort.SearchInts(s.placed1, j)
+	return i < len(s.placed1) && s.placed1[i] == j
+}
+
+// toA returns the index in A of the item kept at j in B
+func (s listSteps) toA(j int) int {
+	return nthNotIn(s.removed1, j-sort.SearchInts(s.placed1, j))
+}
+
+// toC returns the index in C of the item at j in B, if it survives the second delta
+func (s listSteps) toC(j int) (int, bool) {
+	if l, found := s.changedFrom2[j]; found {
+		return l, true
+	}
+	i := sort.SearchInts(s.removed2, j)
+	if i < len(s.removed2) && s.removed2[i] == j {
+		return 0, false
+	}
+	return nthNotIn(s.placed2, j-i), true
+}
+
+// nthNotIn returns the nth index, counting from zero, that isn't in the sorted set skip
+func nthNotIn(skip []int, n int) int {
+	index := n
+	for _, k := range skip {
+		if k > index {
+			break
+		}
+		index++
+	}
+	return index
+}
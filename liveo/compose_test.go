@@ -0,0 +1,36 @@
+package liveo
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestComposeDeltas(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		A := randomResultSet(r)
+		B := mutateResultSet(r, A)
+		C := mutateResultSet(r, B)
+
+		composed, err := A.DeltaTo(B).Compose(B.DeltaTo(C))
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if composed.Old != A.Hash || composed.New != C.Hash {
+			t.Fatalf("case %d: composed delta from %d to %d, want %d to %d", i, composed.Old, composed.New, A.Hash, C.Hash)
+		}
+		got, err := A.Apply(composed)
+		if err != nil {
+			t.Fatalf("case %d: %s\nA: %+v\nB: %+v\nC: %+v\ncomposed: %+v", i, err, A, B, C, composed)
+		}
+		if !sameResults(got.Results, C.Results) || got.Hash != C.Hash {
+			t.Fatalf("case %d: applying the composed delta gave\n%+v\nwant\n%+v", i, got, C)
+		}
+	}
+}
+
+func TestComposeRejectsGap(t *testing.T) {
+	if _, err := (ResultDelta{Old: 1, New: 2}).Compose(ResultDelta{Old: 3, New: 4}); err == nil {
+		t.Error("composed deltas which don't follow on")
+	}
+}
@@ -0,0 +1,74 @@
+package liveo
+
+import (
+	"compress/flate"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// A CompressedConn wraps a net.Conn in a DEFLATE stream, for use once CapDeflate has been agreed.
+// Each Write is flushed to the connection so RPC calls aren't held back waiting for more data,
+// while the compression window carries over between writes. The counters record bytes before
+// and after compression on the write side.
+type CompressedConn struct {
+	net.Conn
+
+	r io.ReadCloser
+
+	wMu sync.Mutex
+	w   *flate.Writer
+
+	rawOut        uint64
+	compressedOut uint64
+}
+
+// NewCompressedConn wraps conn. Both ends of a connection must be wrapped.
+func NewCompressedConn(conn net.Conn) *CompressedConn {
+	c := &CompressedConn{
+		Conn: conn,
+		r:    flate.NewReader(conn),
+	}
+	c.w, _ = flate.NewWriter(countingWriter{conn, &c.compressedOut}, flate.DefaultCompression)
+	return c
+}
+
+// Read reads decompressed data from the connection
+func (c *CompressedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Write compresses p onto the connection
+func (c *CompressedConn) Write(p []byte) (int, error) {
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+	n, err := c.w.Write(p)
+	atomic.AddUint64(&c.rawOut, uint64(n))
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+// Close closes the underlying connection
+func (c *CompressedConn) Close() error {
+	c.r.Close()
+	return c.Conn.Close()
+}
+
+// WriteCounts returns the total bytes written so far, before and after compression
+func (c *CompressedConn) WriteCounts() (raw, compressed uint64) {
+	return atomic.LoadUint64(&c.rawOut), atomic.LoadUint64(&c.compressedOut)
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count *uint64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddUint64(cw.count, uint64(n))
+	return n, err
+}
@@ -0,0 +1,113 @@
+package liveo
+
+// Identity returns the course's ID, or its title if it has none
+func (c Course) Identity() string {
+	if c.ID != "" {
+		return c.ID
+	}
+	return "title:" + c.Title
+}
+
+// A CourseChange describes a change to a course's details, such as a corrected title or info.
+// The changed course is the one at index From in the old list, with any non-nil fields replaced.
+// Its competitors are kept, and any changes to them are given in ResultDelta.Competitors as for
+// any other course.
+type CourseChange struct {
+	From  int
+	Title *string `json:",omitempty"`
+	Info  *string `json:",omitempty"`
+}
+
+// courseChangeBetween returns the change turning course a, found at index from, into b
+func courseChangeBetween(from int, a, b Course) CourseChange {
+	change := CourseChange{From: from}
+	if a.Title != b.Title {
+		change.Title = &b.Title
+	}
+	if a.Info != b.Info {
+		change.Info = &b.Info
+	}
+	return change
+}
+
+// applyTo returns course c with the change made
+func (change CourseChange) applyTo(c Course) Course {
+	if change.Title != nil {
+		c.Title = *change.Title
+	}
+	if change.Info != nil {
+		c.Info = *change.Info
+	}
+	return c
+}
+
+func (change CourseChange) from() int {
+	return change.From
+}
+
+// findCourseChanges pairs courses removed from A with courses added in their place, which are
+// modifications rather than new courses. Courses are paired by identity, then courses without
+// an ID are paired by position, so that a corrected title is also recognised. Paired courses are
+// moved from added into the returned changes.
+func findCourseChanges(A []Course, removed map[int]int, added map[int]Course) map[int]CourseChange {
+	// A course's slot is the number of kept courses before it, which is the same in both lists
+	slotsA := map[int]int{}
+	for i := range A {
+		if _, found := removed[i]; found {
+			slotsA[i] = i - len(slotsA)
+		}
+	}
+	slotsB := map[int]int{}
+	for _, j := range sortedKeys(added) {
+		slotsB[j] = j - len(slotsB)
+	}
+
+	pairs := pairByIdentity(A, removed, added, Course.Identity)
+	paired := map[int]bool{}
+	for _, from := range pairs {
+		paired[from] = true
+	}
+	removedBySlot := map[int][]int{}
+	for i := range A {
+		if _, found := removed[i]; found && !paired[i] && A[i].ID == "" {
+			removedBySlot[slotsA[i]] = append(removedBySlot[slotsA[i]], i)
+		}
+	}
+	for _, j := range sortedKeys(added) {
+		if _, found := pairs[j]; found || added[j].ID != "" {
+			continue
+		}
+		candidates := removedBySlot[slotsB[j]]
+		if len(candidates) == 0 {
+			continue
+		}
+		pairs[j] = candidates[0]
+		removedBySlot[slotsB[j]] = candidates[1:]
+	}
+
+	var changed map[int]CourseChange
+	for j, from := range pairs {
+		if changed == nil {
+			changed = map[int]CourseChange{}
+		}
+		changed[j] = courseChangeBetween(from, A[from], added[j])
+		delete(added, j)
+	}
+	return changed
+}
+
+func (change CourseChange) withFrom(from int) CourseChange {
+	change.From = from
+	return change
+}
+
+// then returns the change made by this change followed by later
+func (change CourseChange) then(later CourseChange) CourseChange {
+	if later.Title != nil {
+		change.Title = later.Title
+	}
+	if later.Info != nil {
+		change.Info = later.Info
+	}
+	return change
+}
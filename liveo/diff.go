@@ -0,0 +1,74 @@
+package liveo
+
+import (
+	"sort"
+
+	"github.com/fivegreenapples/live-o-results/lcs"
+)
+
+// diffList compares lists A and B by the keys of their items, producing the removals and
+// additions that turn A into B in the form used by CoursesDelta and CompetitorsDelta. Removed
+// holds indices into A and added holds items of B by their index in B. Items common to both are
+// reported in common, mapping each index in B to the matching index in A. Duplicate keys are
+// matched in order of appearance.
+func diffList[T any, K comparable](A, B []T, key func(T) K) (removed map[int]int, added map[int]T, common map[int]int) {
+	removed, added, common = map[int]int{}, map[int]T{}, map[int]int{}
+
+	keysA, keysB := make([]K, len(A)), make([]K, len(B))
+	for i, item := range A {
+		keysA[i] = key(item)
+	}
+	for i, item := range B {
+		keysB[i] = key(item)
+	}
+	for _, edit := range lcs.Diff(keysA, keysB) {
+		switch edit.Op {
+		case lcs.Keep:
+			common[edit.B] = edit.A
+		case lcs.Delete:
+			removed[edit.A] = 0
+		case lcs.Insert:
+			added[edit.B] = B[edit.B]
+		}
+	}
+
+	return removed, added, common
+}
+
+// pairByIdentity pairs items removed from A with items added having the same identity, which are
+// changes to an item rather than a removal and an unrelated addition. The pairs map each index in
+// B to the index in A of the same item. Should an identity be shared, items are paired in order.
+func pairByIdentity[T any](A []T, removed map[int]int, added map[int]T, identity func(T) string) map[int]int {
+	removedByIdentity := map[string][]int{}
+	for i := range A {
+		if _, found := removed[i]; found {
+			id := identity(A[i])
+			removedByIdentity[id] = append(removedByIdentity[id], i)
+		}
+	}
+
+	pairs := map[int]int{}
+	for _, j := range sortedKeys(added) {
+		id := identity(added[j])
+		candidates := removedByIdentity[id]
+		if len(candidates) == 0 {
+			continue
+		}
+		pairs[j] = candidates[0]
+		if len(candidates) == 1 {
+			delete(removedByIdentity, id)
+		} else {
+			removedByIdentity[id] = candidates[1:]
+		}
+	}
+	return pairs
+}
+
+func sortedKeys[T any](m map[int]T) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
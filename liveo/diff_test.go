@@ -0,0 +1,77 @@
+package liveo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffList(t *testing.T) {
+	tests := []struct {
+		name        string
+		A, B        string
+		wantRemoved map[int]int
+		wantAdded   map[int]string
+		wantCommon  map[int]int
+	}{
+		{
+			name: "both empty",
+		},
+		{
+			name:        "all removed",
+			A:           "abc",
+			wantRemoved: map[int]int{0: 0, 1: 0, 2: 0},
+		},
+		{
+			name:      "all added",
+			B:         "abc",
+			wantAdded: map[int]string{0: "a", 1: "b", 2: "c"},
+		},
+		{
+			name:        "interleaved",
+			A:           "abcd",
+			B:           "axcy",
+			wantRemoved: map[int]int{1: 0, 3: 0},
+			wantAdded:   map[int]string{1: "x", 3: "y"},
+			wantCommon:  map[int]int{0: 0, 2: 2},
+		},
+		{
+			name:        "duplicate keys",
+			A:           "aab",
+			B:           "abaa",
+			wantRemoved: map[int]int{1: 0},
+			wantAdded:   map[int]string{2: "a", 3: "a"},
+			wantCommon:  map[int]int{0: 0, 1: 2},
+		},
+		{
+			name:        "duplicate key removed",
+			A:           "abab",
+			B:           "aab",
+			wantRemoved: map[int]int{1: 0},
+			wantCommon:  map[int]int{0: 0, 1: 2, 2: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			A, B := strings.Split(tt.A, ""), strings.Split(tt.B, "")
+			removed, added, common := diffList(A, B, func(s string) string { return s })
+			if !sameMap(removed, tt.wantRemoved) {
+				t.Errorf("removed %v, want %v", removed, tt.wantRemoved)
+			}
+			if !sameMap(added, tt.wantAdded) {
+				t.Errorf("added %v, want %v", added, tt.wantAdded)
+			}
+			if !sameMap(common, tt.wantCommon) {
+				t.Errorf("common %v, want %v", common, tt.wantCommon)
+			}
+		})
+	}
+}
+
+// sameMap compares maps, treating nil and empty as equal
+func sameMap[V any](a, b map[int]V) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,111 @@
+package liveo
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+
+	"github.com/mitchellh/hashstructure"
+)
+
+// Results are hashed with SHA-256 over a canonical encoding, so that a hash depends only on the
+// results themselves and not on any library or struct layout. A course is encoded as
+//
+//	string Title, string Info, uint32 number of competitors, then for each competitor
+//	string Name, string AgeClass, string Club, int64 Time in nanoseconds, byte Valid (0 or 1)
+//
+// and a set of results as
+//
+//	string Title, uint32 number of courses, then the uint64 hash of each course
+//
+// where integers are big-endian and a string is its uint32 length in bytes followed by its UTF-8
+// bytes. A hash is the first 8 bytes of the SHA-256 digest read as a big-endian uint64. IDs are
+// not hashed.
+
+// HashResults returns the hash identifying a set of results, as used for ResultDataSet.Hash
+func HashResults(r Results) uint64 {
+	return hashFromCourses(r.Title, CourseHashes(r))
+}
+
+// HashCourse returns the hash of a single course, which HashResults combines to hash a set
+func HashCourse(c Course) uint64 {
+	h := sha256.New()
+	writeString(h, c.Title)
+	writeString(h, c.Info)
+	writeUint32(h, uint32(len(c.Competitors)))
+	for _, cp := range c.Competitors {
+		writeString(h, cp.Name)
+		writeString(h, cp.AgeClass)
+		writeString(h, cp.Club)
+		writeUint64(h, uint64(cp.Time))
+		if cp.Valid {
+			h.Write([]byte{1})
+		} else {
+			h.Write([]byte{0})
+		}
+	}
+	return sum64(h)
+}
+
+// CourseHashes returns the hash of each course of r
+func CourseHashes(r Results) []uint64 {
+	hashes := make([]uint64, len(r.Courses))
+	for i, c := range r.Courses {
+		hashes[i] = HashCourse(c)
+	}
+	return hashes
+}
+
+func hashFromCourses(title string, courseHashes []uint64) uint64 {
+	h := sha256.New()
+	writeString(h, title)
+	writeUint32(h, uint32(len(courseHashes)))
+	for _, courseHash := range courseHashes {
+		writeUint64(h, courseHash)
+	}
+	return sum64(h)
+}
+
+// LegacyHashResults returns the hash used by peers without CapContentHash
+func LegacyHashResults(r Results) uint64 {
+	hash, _ := hashstructure.Hash(r, nil)
+	return hash
+}
+
+func writeString(h hash.Hash, s string) {
+	writeUint32(h, uint32(len(s)))
+	h.Write([]byte(s))
+}
+func writeUint32(h hash.Hash, n uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	h.Write(b[:])
+}
+func writeUint64(h hash.Hash, n uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	h.Write(b[:])
+}
+func sum64(h hash.Hash) uint64 {
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// ResyncDelta returns a delta turning results known only by their hash and course hashes into B,
+// resending just the courses whose hashes differ. It repairs a receiver whose results have
+// diverged from ours.
+func ResyncDelta(oldHash uint64, oldCourseHashes []uint64, B ResultDataSet) ResultDelta {
+	removed, added, _ := diffList(oldCourseHashes, CourseHashes(B.Results), func(h uint64) uint64 { return h })
+	courses := make(map[int]Course, len(added))
+	for j := range added {
+		courses[j] = B.Results.Courses[j]
+	}
+	return ResultDelta{
+		Old:   oldHash,
+		New:   B.Hash,
+		Title: &B.Results.Title,
+		Courses: &CoursesDelta{
+			Removed: removed,
+			Added:   courses,
+		},
+	}
+}
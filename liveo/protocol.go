@@ -0,0 +1,158 @@
+package liveo
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion is the version of the RPC protocol spoken between filewatcher and ResultServer.
+// Additions to the protocol are announced as capabilities, which peers must check before using
+// them. The version is bumped only for changes older peers can't be spoken to across, raising
+// MinProtocolVersion to match.
+const ProtocolVersion = 2
+
+// LegacyProtocolVersion is assumed for peers that don't announce a version. These predate
+// negotiation and support only LegacyCapabilities.
+const LegacyProtocolVersion = 1
+
+// MinProtocolVersion is the oldest version this build speaks. Peers announcing an older one are
+// refused at connect time.
+const MinProtocolVersion = LegacyProtocolVersion
+
+// Handshake headers exchanged on the RPC upgrade request and its 101 response
+const (
+	ProtocolVersionHeader = "X-Liveo-Protocol"
+	CapabilitiesHeader    = "X-Liveo-Capabilities"
+)
+
+// Capabilities which may be agreed at connect time
+const (
+	// CapDelta allows ResultDeltas to be submitted in place of full result sets
+	CapDelta = "delta"
+	// CapDeflate compresses the RPC stream in both directions, see CompressedConn
+	CapDeflate = "deflate"
+	// CapPing means the ResultServer answers Api.Ping heartbeats
+	CapPing = "ping"
+	// CapChanges allows deltas to carry competitor corrections in CompetitorsDelta.Changed
+	CapChanges = "changes"
+	// CapCourseChanges allows deltas to carry course modifications in CoursesDelta.Modified
+	CapCourseChanges = "coursechanges"
+	// CapContentHash means result sets are identified by HashResults' canonical content hash,
+	// rather than LegacyHashResults, and the ResultServer answers Api.CourseHashes
+	CapContentHash = "contenthash"
+)
+
+// Capabilities lists everything supported by this build
+var Capabilities = []string{CapDelta, CapDeflate, CapPing, CapChanges, CapCourseChanges, CapContentHash}
+
+// LegacyCapabilities lists what a peer that doesn't announce capabilities supports
+var LegacyCapabilities = []string{CapDelta}
+
+// A Handshake is one side's announcement of its protocol version and capabilities, or the
+// agreed result of combining both sides.
+type Handshake struct {
+	Version      int
+	Capabilities []string
+}
+
+// LocalHandshake returns the handshake announcing this build's protocol support
+func LocalHandshake() Handshake {
+	return Handshake{
+		Version:      ProtocolVersion,
+		Capabilities: Capabilities,
+	}
+}
+
+// ReadHandshake extracts a peer's handshake from h, falling back to the legacy protocol when the
+// peer didn't announce one.
+func ReadHandshake(h http.Header) Handshake {
+	v := h.Get(ProtocolVersionHeader)
+	if v == "" {
+		return Handshake{
+			Version:      LegacyProtocolVersion,
+			Capabilities: LegacyCapabilities,
+		}
+	}
+	hs := Handshake{
+		Version:      LegacyProtocolVersion,
+		Capabilities: []string{},
+	}
+	if version, err := strconv.Atoi(v); err == nil && version > 0 {
+		hs.Version = version
+	}
+	for _, c := range strings.Split(h.Get(CapabilitiesHeader), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			hs.Capabilities = append(hs.Capabilities, c)
+		}
+	}
+	return hs
+}
+
+// Write adds the handshake to h
+func (hs Handshake) Write(h http.Header) {
+	h.Set(ProtocolVersionHeader, strconv.Itoa(hs.Version))
+	h.Set(CapabilitiesHeader, strings.Join(hs.Capabilities, ","))
+}
+
+// Agree combines our handshake with a peer's, producing the lower of the two versions and the
+// capabilities common to both.
+func (hs Handshake) Agree(peer Handshake) Handshake {
+	agreed := Handshake{
+		Version:      hs.Version,
+		Capabilities: []string{},
+	}
+	if peer.Version < agreed.Version {
+		agreed.Version = peer.Version
+	}
+	for _, c := range hs.Capabilities {
+		if peer.Has(c) {
+			agreed.Capabilities = append(agreed.Capabilities, c)
+		}
+	}
+	return agreed
+}
+
+// CheckVersion returns an error if a peer's handshake announces a version this build no longer
+// speaks. Peers announcing a newer version are spoken to in ours, and refuse us themselves if
+// they can't.
+func (hs Handshake) CheckVersion() error {
+	if hs.Version < MinProtocolVersion {
+		return fmt.Errorf("protocol v%d is no longer supported, v%d or later is required", hs.Version, MinProtocolVersion)
+	}
+	return nil
+}
+
+// Has reports whether capability c is included in the handshake
+func (hs Handshake) Has(c string) bool {
+	for _, hc := range hs.Capabilities {
+		if hc == c {
+			return true
+		}
+	}
+	return false
+}
+
+// PingArgs is sent by Api.Ping heartbeats
+type PingArgs struct {
+	Sent time.Time
+}
+
+// PingReply answers an Api.Ping heartbeat with the ResultServer's clock and the hash of the
+// results it holds.
+type PingReply struct {
+	ServerTime time.Time
+	Hash       uint64
+}
+
+// CourseHashesArgs requests the hashes of a ResultServer's results via Api.CourseHashes
+type CourseHashesArgs struct{}
+
+// CourseHashesReply gives the hash of the results a ResultServer holds, and of each of its
+// courses, allowing a producer to resend only the courses that differ
+type CourseHashesReply struct {
+	Hash    uint64
+	Courses []uint64
+}
@@ -0,0 +1,60 @@
+package liveo
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHandshakeAgree(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    Handshake
+		wantErr bool
+	}{
+		{
+			name: "legacy peer",
+			want: Handshake{Version: LegacyProtocolVersion, Capabilities: []string{CapDelta}},
+		},
+		{
+			name:    "current peer",
+			headers: map[string]string{ProtocolVersionHeader: "2", CapabilitiesHeader: "delta, ping,unknown"},
+			want:    Handshake{Version: 2, Capabilities: []string{CapDelta, CapPing}},
+		},
+		{
+			name:    "newer peer",
+			headers: map[string]string{ProtocolVersionHeader: "7", CapabilitiesHeader: "contenthash"},
+			want:    Handshake{Version: ProtocolVersion, Capabilities: []string{CapContentHash}},
+		},
+		{
+			name:    "unreadable version",
+			headers: map[string]string{ProtocolVersionHeader: "two"},
+			want:    Handshake{Version: LegacyProtocolVersion, Capabilities: []string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			peer := ReadHandshake(h)
+			if err := peer.CheckVersion(); err != nil {
+				t.Fatal(err)
+			}
+			if got := LocalHandshake().Agree(peer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("agreed %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandshakeCheckVersion(t *testing.T) {
+	if err := (Handshake{Version: MinProtocolVersion - 1}).CheckVersion(); err == nil {
+		t.Error("a version older than MinProtocolVersion was accepted")
+	}
+	if err := LocalHandshake().CheckVersion(); err != nil {
+		t.Error("our own version was refused:", err)
+	}
+}
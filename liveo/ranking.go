@@ -0,0 +1,74 @@
+package liveo
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Standing is a competitor's standing on their course. Competitors without a valid result
+// have a zero Standing.
+type Standing struct {
+	// Position counts from 1, with competitors on equal times sharing a position
+	Position int
+	// Behind is the time behind the winner
+	Behind time.Duration
+	// PercentBehind is Behind as a percentage of the winner's time
+	PercentBehind float64
+	// Pace is time per km, or zero if the course's distance isn't known
+	Pace time.Duration
+}
+
+var reDistance = regexp.MustCompile(`(?i)\b([0-9]+(?:[.,][0-9]+)?)\s*km\b`)
+
+// Distance returns the length of the course in km as given in its info, such as "5.2km" or
+// "5,2 km"
+func (c Course) Distance() (float64, bool) {
+	matches := reDistance.FindStringSubmatch(c.Info)
+	if matches == nil {
+		return 0, false
+	}
+	km, err := strconv.ParseFloat(strings.Replace(matches[1], ",", ".", 1), 64)
+	if err != nil || km <= 0 {
+		return 0, false
+	}
+	return km, true
+}
+
+// Rank returns the standing of each competitor of c. Competitors needn't be in finishing order.
+func Rank(c Course) []Standing {
+	standings := make([]Standing, len(c.Competitors))
+
+	times := []time.Duration{}
+	for _, cp := range c.Competitors {
+		if cp.Valid {
+			times = append(times, cp.Time)
+		}
+	}
+	if len(times) == 0 {
+		return standings
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	winner := times[0]
+	km, knownDistance := c.Distance()
+
+	for i, cp := range c.Competitors {
+		if !cp.Valid {
+			continue
+		}
+		standing := Standing{
+			Position: sort.Search(len(times), func(k int) bool { return times[k] >= cp.Time }) + 1,
+			Behind:   cp.Time - winner,
+		}
+		if winner > 0 {
+			standing.PercentBehind = float64(standing.Behind) / float64(winner) * 100
+		}
+		if knownDistance {
+			standing.Pace = time.Duration(float64(cp.Time) / km)
+		}
+		standings[i] = standing
+	}
+	return standings
+}
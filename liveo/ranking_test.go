@@ -0,0 +1,124 @@
+package liveo
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRank(t *testing.T) {
+	const min = time.Minute
+	for _, test := range []struct {
+		name        string
+		info        string
+		competitors []Competitor
+		want        []Standing
+	}{
+		{
+			name: "empty",
+			want: []Standing{},
+		},
+		{
+			name: "unordered with a distance",
+			info: "5km",
+			competitors: []Competitor{
+				{Name: "b", Time: 50 * min, Valid: true},
+				{Name: "a", Time: 40 * min, Valid: true},
+				{Name: "c", Time: 60 * min, Valid: true},
+			},
+			want: []Standing{
+				{Position: 2, Behind: 10 * min, PercentBehind: 25, Pace: 10 * min},
+				{Position: 1, Pace: 8 * min},
+				{Position: 3, Behind: 20 * min, PercentBehind: 50, Pace: 12 * min},
+			},
+		},
+		{
+			name: "tied times share a position",
+			competitors: []Competitor{
+				{Name: "a", Time: 40 * min, Valid: true},
+				{Name: "b", Time: 40 * min, Valid: true},
+				{Name: "c", Time: 30 * min, Valid: true},
+				{Name: "d", Time: 45 * min, Valid: true},
+			},
+			want: []Standing{
+				{Position: 2, Behind: 10 * min, PercentBehind: float64(10*min) / float64(30*min) * 100},
+				{Position: 2, Behind: 10 * min, PercentBehind: float64(10*min) / float64(30*min) * 100},
+				{Position: 1},
+				{Position: 4, Behind: 15 * min, PercentBehind: 50},
+			},
+		},
+		{
+			name: "invalid competitors are unranked and don't set the winning time",
+			competitors: []Competitor{
+				{Name: "a", Time: 20 * min, Valid: false},
+				{Name: "b", Time: 40 * min, Valid: true},
+				{Name: "c", Valid: false},
+				{Name: "d", Time: 50 * min, Valid: true},
+			},
+			want: []Standing{
+				{},
+				{Position: 1},
+				{},
+				{Position: 2, Behind: 10 * min, PercentBehind: 25},
+			},
+		},
+		{
+			name: "all invalid",
+			info: "3.2km",
+			competitors: []Competitor{
+				{Name: "a", Time: 20 * min},
+				{Name: "b"},
+			},
+			want: []Standing{{}, {}},
+		},
+	} {
+		got := Rank(Course{Title: "Test", Info: test.info, Competitors: test.competitors})
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got\n%+v\nwant\n%+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	for _, test := range []struct {
+		info  string
+		km    float64
+		found bool
+	}{
+		{"6.3km 210m", 6.3, true},
+		{"5 KM", 5, true},
+		{"2,5 km", 2.5, true},
+		{"Climb 120m, 4.1km", 4.1, true},
+		{"", 0, false},
+		{"210m climb", 0, false},
+		{"0km", 0, false},
+		{"see 12kmh map", 0, false},
+	} {
+		km, found := Course{Info: test.info}.Distance()
+		if km != test.km || found != test.found {
+			t.Errorf("Distance of %q: %v %v, want %v %v", test.info, km, found, test.km, test.found)
+		}
+	}
+}
+
+func BenchmarkRank(b *testing.B) {
+	for _, n := range []int{300, 1000} {
+		r := rand.New(rand.NewSource(int64(n)))
+		c := Course{Title: "Brown", Info: "10.2km 350m"}
+		for i := 0; i < n; i++ {
+			c.Competitors = append(c.Competitors, Competitor{
+				Name:  fmt.Sprint("Runner ", i),
+				Time:  time.Duration(3000+r.Intn(4000)) * time.Second,
+				Valid: r.Intn(20) > 0,
+			})
+		}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Rank(c)
+			}
+		})
+	}
+}
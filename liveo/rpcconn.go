@@ -0,0 +1,164 @@
+package liveo
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SubscribeEndpoint stores the URI path at which a filewatcher accepts connections from
+// ResultServers pulling results, rather than having them pushed.
+var SubscribeEndpoint = "/subscribe"
+
+/*
+GET /api HTTP/1.0
+Connection: Upgrade
+Upgrade: RPC
+X-Liveo-Protocol: 2
+X-Liveo-Capabilities: delta,deflate
+Content-Length: 64
+
+a565baf1712cf73eafa88d4ccea182c12ad8f6c1fa0d40184bd52d056082890d
+*/
+
+// DialRPC connects to address and upgrades the connection at endpoint, returning it ready to carry
+// RPC along with the agreed protocol. The returned connection is a *CompressedConn if compression
+// was agreed. Without explicit tlsSettings TLS is used only for port 443.
+func DialRPC(address, endpoint string, tlsSettings *TLSSettings) (net.Conn, Handshake, error) {
+	var err error
+	var host, port string
+
+	lastColonPos := strings.LastIndex(address, ":")
+	if lastColonPos == -1 {
+		host = address
+		port = "80"
+	} else {
+		host, port, err = net.SplitHostPort(address)
+		if err != nil {
+			return nil, Handshake{}, err
+		}
+	}
+
+	settings := TLSSettings{Enabled: port == "443"}
+	if tlsSettings != nil {
+		settings = *tlsSettings
+	}
+	tlsConfig, err := settings.ClientConfig(host)
+	if err != nil {
+		return nil, Handshake{}, err
+	}
+
+	var conn net.Conn
+	dialTimeout := 5 * time.Second
+	if tlsConfig != nil {
+		dialer := net.Dialer{Timeout: dialTimeout}
+		conn, err = tls.DialWithDialer(&dialer, "tcp", net.JoinHostPort(host, port), tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", net.JoinHostPort(host, port), dialTimeout)
+	}
+	if err != nil {
+		return nil, Handshake{}, err
+	}
+
+	httpRequest := "GET " + endpoint + " HTTP/1.0\r\n"
+	httpRequest += fmt.Sprintf("Host: %s\r\n", host)
+	httpRequest += fmt.Sprint("Connection: Upgrade\r\n")
+	httpRequest += fmt.Sprint("Upgrade: RPC\r\n")
+	httpRequest += fmt.Sprintf("%s: %d\r\n", ProtocolVersionHeader, ProtocolVersion)
+	httpRequest += fmt.Sprintf("%s: %s\r\n", CapabilitiesHeader, strings.Join(Capabilities, ","))
+	httpRequest += fmt.Sprintf("Content-Length: %d\r\n", len(SharedSecret))
+	httpRequest += "\r\n"
+	httpRequest += SharedSecret
+	io.WriteString(conn, httpRequest)
+
+	// Require successful HTTP response
+	// before switching to RPC protocol.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err != nil {
+		conn.Close()
+		return nil, Handshake{}, err
+	}
+	if resp.Status != RPCConnectedStatus {
+		conn.Close()
+		return nil, Handshake{}, errors.New("unexpected HTTP response: " + resp.Status)
+	}
+
+	// Servers that predate negotiation send no handshake and are treated as legacy
+	peer := ReadHandshake(resp.Header)
+	if err := peer.CheckVersion(); err != nil {
+		conn.Close()
+		return nil, Handshake{}, err
+	}
+	protocol := LocalHandshake().Agree(peer)
+
+	// Reset deadline so we don't lose the connection
+	conn.SetDeadline(time.Time{})
+
+	if protocol.Has(CapDeflate) {
+		conn = NewCompressedConn(conn)
+	}
+	return conn, protocol, nil
+}
+
+// AcceptRPC validates an upgrade request made by DialRPC and hijacks its connection, returning it
+// ready to carry RPC along with the agreed protocol. If the request is refused an error response
+// is written and ok is false.
+func AcceptRPC(w http.ResponseWriter, req *http.Request) (conn net.Conn, protocol Handshake, ok bool) {
+	if req.Method != "GET" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return nil, Handshake{}, false
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "500 "+err.Error()+"\n")
+		return nil, Handshake{}, false
+	}
+	if string(body) != SharedSecret {
+		log.Println("Unauthorized " + string(body))
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "401 Unauthorized\n")
+		return nil, Handshake{}, false
+	}
+
+	// Clients that predate negotiation send no handshake and are treated as legacy
+	peer := ReadHandshake(req.Header)
+	if err := peer.CheckVersion(); err != nil {
+		log.Println("Refusing RPC connection:", err)
+		w.WriteHeader(http.StatusUpgradeRequired)
+		io.WriteString(w, "426 "+err.Error()+"\n")
+		return nil, Handshake{}, false
+	}
+
+	conn, _, err = w.(http.Hijacker).Hijack()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "500 "+err.Error()+"\n")
+		return nil, Handshake{}, false
+	}
+	conn.SetDeadline(time.Time{})
+
+	// Clients that predate negotiation ignore the handshake headers
+	protocol = LocalHandshake().Agree(peer)
+	respHeader := http.Header{}
+	protocol.Write(respHeader)
+	io.WriteString(conn, "HTTP/1.0 "+RPCConnectedStatus+"\r\n")
+	respHeader.Write(conn)
+	io.WriteString(conn, "\r\n")
+
+	if protocol.Has(CapDeflate) {
+		conn = NewCompressedConn(conn)
+	}
+	return conn, protocol, true
+}
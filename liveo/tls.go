@@ -0,0 +1,143 @@
+package liveo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSSettings describes how an RPC connection to a ResultServer is secured. CAFile, when set,
+// replaces the system roots for verifying the server. CertFile and KeyFile supply a client
+// certificate for servers requiring mutual TLS. ServerName overrides the name verified against
+// the server's certificate, which otherwise defaults to the host being dialled.
+type TLSSettings struct {
+	Enabled    bool
+	CAFile     string `json:",omitempty"`
+	ServerName string `json:",omitempty"`
+	CertFile   string `json:",omitempty"`
+	KeyFile    string `json:",omitempty"`
+}
+
+// ClientConfig builds a tls.Config for dialling host according to the settings. A nil config
+// is returned when TLS is not enabled.
+func (s TLSSettings) ClientConfig(host string) (*tls.Config, error) {
+	if !s.Enabled {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName: host,
+	}
+	if s.ServerName != "" {
+		config.ServerName = s.ServerName
+	}
+
+	if s.CAFile != "" {
+		pool, err := LoadCertPool(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if s.CertFile != "" || s.KeyFile != "" {
+		if s.CertFile == "" || s.KeyFile == "" {
+			return nil, errors.New("tls: client certificate requires both a cert file and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates from file
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls: no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// A CertReloader serves a certificate and key pair from disk, reloading them whenever either
+// file changes. Use GetCertificate as tls.Config.GetCertificate so renewed certificates are
+// picked up without restarting the server.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+// certCheckInterval limits how often the certificate files are checked for changes
+var certCheckInterval = 10 * time.Second
+
+// NewCertReloader loads the given certificate and key, failing if they are not a valid pair
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.lastCheck = time.Now()
+	if r.cert != nil && certInfo.ModTime().Equal(r.certMod) && keyInfo.ModTime().Equal(r.keyMod) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil {
+		log.Println("tls: reloaded certificate from", r.certFile)
+	}
+	r.cert = &cert
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if the files have changed.
+// If a reload fails the previous certificate continues to be served.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.lastCheck) >= certCheckInterval {
+		if err := r.reload(); err != nil {
+			log.Println("tls: certificate reload failed, keeping previous certificate:", err)
+		}
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,139 @@
+package liveo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/internal/testcerts"
+)
+
+func TestClientConfigRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	ca := testcerts.NewCA(t, dir)
+	serverCert, serverKey := ca.Issue(t, dir, "server")
+	clientCert, clientKey := ca.Issue(t, dir, "client")
+
+	reloader, err := NewCertReloader(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := LoadCertPool(ca.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		ClientCAs:      pool,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	peer := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			peer <- err.Error()
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil {
+			peer <- err.Error()
+			return
+		}
+		peer <- tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}()
+
+	settings := TLSSettings{Enabled: true, CAFile: ca.File, CertFile: clientCert, KeyFile: clientKey}
+	config, err := settings.ClientConfig("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", ln.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := <-peer; got != "client" {
+		t.Errorf("server saw client %q, want %q", got, "client")
+	}
+	if got := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; got != "server" {
+		t.Errorf("client saw server %q, want %q", got, "server")
+	}
+}
+
+func TestClientConfigSettings(t *testing.T) {
+	dir := t.TempDir()
+	ca := testcerts.NewCA(t, dir)
+	cert, _ := ca.Issue(t, dir, "client")
+
+	if config, err := (TLSSettings{}).ClientConfig("localhost"); config != nil || err != nil {
+		t.Errorf("disabled settings gave %v, %v; want nil, nil", config, err)
+	}
+	config, err := TLSSettings{Enabled: true, ServerName: "results.example"}.ClientConfig("localhost")
+	if err != nil || config.ServerName != "results.example" {
+		t.Errorf("ServerName not overridden: %v, %v", config, err)
+	}
+	if _, err := (TLSSettings{Enabled: true, CertFile: cert}).ClientConfig("localhost"); err == nil {
+		t.Error("a client certificate without a key was accepted")
+	}
+	if _, err := (TLSSettings{Enabled: true, CAFile: cert + ".missing"}).ClientConfig("localhost"); err == nil {
+		t.Error("a missing CA file was accepted")
+	}
+}
+
+func TestCertReloaderRotation(t *testing.T) {
+	defer func(interval time.Duration) { certCheckInterval = interval }(certCheckInterval)
+	certCheckInterval = 0
+
+	dir := t.TempDir()
+	ca := testcerts.NewCA(t, dir)
+	certFile, keyFile := ca.Issue(t, dir, "server")
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := servedSerial(t, reloader)
+
+	// issue a replacement in place, dated later so the change is seen on coarse filesystems
+	ca.Issue(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated := servedSerial(t, reloader)
+	if rotated == first {
+		t.Fatal("rotated certificate wasn't picked up")
+	}
+
+	// a broken renewal keeps the previous certificate in service
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if got := servedSerial(t, reloader); got != rotated {
+		t.Error("certificate changed after a failed reload")
+	}
+}
+
+func servedSerial(t *testing.T, r *CertReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.String()
+}
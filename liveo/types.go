@@ -1,10 +1,7 @@
 package liveo
 
 import (
-	"strconv"
 	"time"
-
-	"github.com/fivegreenapples/live-o-results/lcs"
 )
 
 // SharedSecret is used to ensure communication from to a ResultServer is from a trusted source
@@ -25,8 +22,26 @@ type ResultDataSet struct {
 	Hash    uint64
 }
 
+// courseKey identifies a course when diffing result sets. Any change to a course's details makes
+// it a different course, though findCourseChanges then recognises modifications.
+func courseKey(c Course) [3]string {
+	return [3]string{c.ID, c.Title, c.Info}
+}
+
+// competitorKey identifies a competitor when diffing result sets. Any change to a competitor
+// makes it a different competitor, though findChanges then recognises corrections.
+func competitorKey(c Competitor) Competitor {
+	return c
+}
+
 // DeltaTo produces a ResultDelta as the result of B-A
 func (A ResultDataSet) DeltaTo(B ResultDataSet) ResultDelta {
+	return A.deltaTo(B, true, true)
+}
+
+// deltaTo produces the delta B-A, recognising competitor changes and course modifications only
+// if asked to
+func (A ResultDataSet) deltaTo(B ResultDataSet, competitorChanges, courseChanges bool) ResultDelta {
 
 	delta := ResultDelta{
 		Old: A.Hash,
@@ -37,115 +52,71 @@ func (A ResultDataSet) DeltaTo(B ResultDataSet) ResultDelta {
 		delta.Title = &(B.Results.Title)
 	}
 
-	coursesA, coursesB := []string{}, []string{}
-	coursesBMappings := map[string]Course{} // so we can find new courses added in B
-	courseBtoAMappings := map[int]int{}     // so we can compare competitors in common courses
-	for i, c := range A.Results.Courses {
-		coursesA = append(coursesA, c.Title+"|"+c.Info)
-		courseBtoAMappings[i] = i // init map assuming courses don't differ
-	}
-	for _, c := range B.Results.Courses {
-		coursesB = append(coursesB, c.Title+"|"+c.Info)
-		coursesBMappings[c.Title+"|"+c.Info] = c
-	}
-	coursesCommon := lcs.Calculate(coursesA, coursesB)
-	if len(coursesCommon) != len(coursesA) || len(coursesCommon) != len(coursesB) {
-		// the courses differ
-		coursesDelta := CoursesDelta{
-			Removed: map[int]int{},
-			Added:   map[int]Course{},
+	coursesRemoved, coursesAdded, courseBtoAMappings := diffList(A.Results.Courses, B.Results.Courses, courseKey)
+	var coursesModified map[int]CourseChange
+	if courseChanges {
+		coursesModified = findCourseChanges(A.Results.Courses, coursesRemoved, coursesAdded)
+		for bIndex, change := range coursesModified {
+			courseBtoAMappings[bIndex] = change.From
 		}
-		courseBtoAMappings = map[int]int{} // reset these mappings
-
-		cursorA, cursorB, cursorCom := 0, 0, 0
-		for cursorCom < len(coursesCommon) || cursorA < len(coursesA) || cursorB < len(coursesB) {
-			for cursorCom < len(coursesCommon) &&
-				cursorA < len(coursesA) &&
-				cursorB < len(coursesB) &&
-				coursesCommon[cursorCom] == coursesA[cursorA] &&
-				coursesCommon[cursorCom] == coursesB[cursorB] {
-				// common item
-				// store mapping for competitor comparison
-				courseBtoAMappings[cursorB] = cursorA
-				// move on all cursors
-				cursorCom++
-				cursorA++
-				cursorB++
-			}
-			for cursorA < len(coursesA) &&
-				(cursorCom >= len(coursesCommon) || coursesCommon[cursorCom] != coursesA[cursorA]) {
-				coursesDelta.Removed[cursorA] = 0
-				cursorA++
-			}
-			for cursorB < len(coursesB) &&
-				(cursorCom >= len(coursesCommon) || coursesCommon[cursorCom] != coursesB[cursorB]) {
-				coursesDelta.Added[cursorB] = coursesBMappings[coursesB[cursorB]]
-				cursorB++
-			}
+	}
+	if len(coursesRemoved) > 0 || len(coursesAdded) > 0 {
+		delta.Courses = &CoursesDelta{
+			Removed:  coursesRemoved,
+			Added:    coursesAdded,
+			Modified: coursesModified,
 		}
-
-		delta.Courses = &coursesDelta
 	}
 
-	// Competitor diff analysis. Same as above but for each common group
-	// This diff analysis could do with refactor and generics
-
+	// Competitor diff analysis for each course common to both sets, including modified courses
 	for bIndex, aIndex := range courseBtoAMappings {
-		courseA := A.Results.Courses[aIndex]
-		courseB := B.Results.Courses[bIndex]
-		competitorsA, competitorsB := []string{}, []string{}
-		competitorsBMappings := map[string]Competitor{} // so we can find new competitors added in B
-		for _, c := range courseA.Competitors {
-			ident := c.Name + "|" + c.Club + "|" + c.Time.String() + "|" + strconv.FormatBool(c.Valid)
-			competitorsA = append(competitorsA, ident)
+		competitorsA := A.Results.Courses[aIndex].Competitors
+		competitorsB := B.Results.Courses[bIndex].Competitors
+		removed, added, _ := diffList(competitorsA, competitorsB, competitorKey)
+		if len(removed) == 0 && len(added) == 0 {
+			continue
 		}
-		for _, c := range courseB.Competitors {
-			ident := c.Name + "|" + c.Club + "|" + c.Time.String() + "|" + strconv.FormatBool(c.Valid)
-			competitorsB = append(competitorsB, ident)
-			competitorsBMappings[ident] = c
+		if delta.Competitors == nil {
+			delta.Competitors = &map[int]CompetitorsDelta{}
 		}
-		competitorsCommon := lcs.Calculate(competitorsA, competitorsB)
-		if len(competitorsCommon) != len(competitorsA) || len(competitorsCommon) != len(competitorsB) {
-			// the competitors differ
-			competitorsDelta := CompetitorsDelta{
-				Removed: map[int]int{},
-				Added:   map[int]Competitor{},
-			}
-
-			cursorA, cursorB, cursorCom := 0, 0, 0
-			for cursorCom < len(competitorsCommon) || cursorA < len(competitorsA) || cursorB < len(competitorsB) {
-				for cursorCom < len(competitorsCommon) &&
-					cursorA < len(competitorsA) &&
-					cursorB < len(competitorsB) &&
-					competitorsCommon[cursorCom] == competitorsA[cursorA] &&
-					competitorsCommon[cursorCom] == competitorsB[cursorB] {
-					// common item
-					// move on all cursors
-					cursorCom++
-					cursorA++
-					cursorB++
-				}
-				for cursorA < len(competitorsA) &&
-					(cursorCom >= len(competitorsCommon) || competitorsCommon[cursorCom] != competitorsA[cursorA]) {
-					competitorsDelta.Removed[cursorA] = 0
-					cursorA++
-				}
-				for cursorB < len(competitorsB) &&
-					(cursorCom >= len(competitorsCommon) || competitorsCommon[cursorCom] != competitorsB[cursorB]) {
-					competitorsDelta.Added[cursorB] = competitorsBMappings[competitorsB[cursorB]]
-					cursorB++
-				}
-			}
-
-			if delta.Competitors == nil {
-				delta.Competitors = &map[int]CompetitorsDelta{}
-			}
-			(*delta.Competitors)[bIndex] = competitorsDelta
+		compDelta := CompetitorsDelta{
+			Removed: removed,
+			Added:   added,
 		}
+		if competitorChanges {
+			compDelta.Changed = findChanges(competitorsA, removed, added)
+		}
+		(*delta.Competitors)[bIndex] = compDelta
 	}
 
 	return delta
 }
+
+// ExpandChanges returns d with any competitor changes or course modifications that protocol
+// lacks the capability for given in full as additions. A must be the set d was produced from.
+func (d ResultDelta) ExpandChanges(A ResultDataSet, protocol Handshake) ResultDelta {
+	competitorChanges, courseChanges := protocol.Has(CapChanges), protocol.Has(CapCourseChanges)
+	needed := !courseChanges && d.Courses != nil && len(d.Courses.Modified) > 0
+	if !competitorChanges && d.Competitors != nil {
+		for _, compDelta := range *d.Competitors {
+			needed = needed || len(compDelta.Changed) > 0
+		}
+	}
+	if !needed {
+		return d
+	}
+
+	B, err := A.Apply(d)
+	if err != nil {
+		// A delta we can't apply ourselves will be rejected by the peer too, prompting a
+		// full submission
+		return d
+	}
+	expanded := A.deltaTo(B, competitorChanges, courseChanges)
+	expanded.New = d.New
+	return expanded
+}
+
 func (A ResultDataSet) Clone() ResultDataSet {
 	newResultSet := ResultDataSet{}
 
@@ -157,12 +128,14 @@ func (A ResultDataSet) Clone() ResultDataSet {
 
 	for _, c := range A.Results.Courses {
 		newC := Course{}
+		newC.ID = c.ID
 		newC.Title = c.Title
 		newC.Info = c.Info
 		newC.Competitors = make([]Competitor, 0)
 
 		for _, cp := range c.Competitors {
 			newCP := Competitor{}
+			newCP.ID = cp.ID
 			newCP.Name = cp.Name
 			newCP.AgeClass = cp.AgeClass
 			newCP.Club = cp.Club
@@ -179,6 +152,32 @@ func (A ResultDataSet) Clone() ResultDataSet {
 
 }
 
+// WithCourses returns the result set keeping only the courses for which keep is true, hashed
+// afresh. Viewers following a few courses are sent these in place of the full results.
+func (A ResultDataSet) WithCourses(keep func(Course) bool) ResultDataSet {
+	kept := Results{Title: A.Results.Title, Courses: []Course{}}
+	for _, c := range A.Results.Courses {
+		if keep(c) {
+			kept.Courses = append(kept.Courses, c)
+		}
+	}
+	return ResultDataSet{Results: kept, Hash: HashResults(kept)}
+}
+
+// Following returns the result set with every course but competitors only on those for which
+// follow is true, hashed afresh. Viewers are sent these so that they still learn of every course
+// while receiving results only for the courses they follow.
+func (A ResultDataSet) Following(follow func(Course) bool) ResultDataSet {
+	followed := Results{Title: A.Results.Title, Courses: make([]Course, len(A.Results.Courses))}
+	for i, c := range A.Results.Courses {
+		if !follow(c) {
+			c.Competitors = []Competitor{}
+		}
+		followed.Courses[i] = c
+	}
+	return ResultDataSet{Results: followed, Hash: HashResults(followed)}
+}
+
 // ResultDelta encodes the difference between two ResultDataSets
 type ResultDelta struct {
 	Old         uint64
@@ -188,16 +187,21 @@ type ResultDelta struct {
 	Competitors *map[int]CompetitorsDelta `json:",omitempty"`
 }
 
-// CoursesDelta encodes the difference between two Courses
+// CoursesDelta encodes the difference between two Courses. Modified holds courses whose details
+// were changed, by their index in the new list; each is also listed in Removed at its old index.
 type CoursesDelta struct {
-	Removed map[int]int
-	Added   map[int]Course
+	Removed  map[int]int
+	Added    map[int]Course
+	Modified map[int]CourseChange `json:",omitempty"`
 }
 
-// CompetitorsDelta encodes the difference between two Competitors
+// CompetitorsDelta encodes the difference between two Competitors. Changed holds competitors
+// whose details were corrected, by their index in the new list; each is also listed in Removed
+// at its old index.
 type CompetitorsDelta struct {
 	Removed map[int]int
 	Added   map[int]Competitor
+	Changed map[int]CompetitorChange `json:",omitempty"`
 }
 
 // Results defines a set of orienteering results as published from AutoDownload
@@ -206,16 +210,23 @@ type Results struct {
 	Courses []Course
 }
 
-// Course reperesents course information within a result set.
+// Course reperesents course information within a result set. ID identifies the course across
+// result sets; where none is available the course is identified by its title. AutoDownload's
+// Live HTML gives no course IDs, so only producers using the push API can set one.
 type Course struct {
+	ID          string `json:",omitempty" hash:"ignore"` // not hashed so hashes match peers predating IDs
 	Title       string
 	Info        string
 	Competitors []Competitor
 }
 
 // Competitor represents a particular runner and their time and position within a set
-// of results.
+// of results. ID identifies the runner across result sets, such as by SI card or bib number.
+// Where no ID is available the runner is identified by CompetitorFingerprint. AutoDownload's
+// Live HTML has neither SI card nor bib numbers, so competitors decoded by filewatcher are
+// always identified by fingerprint; only producers using the push API can set IDs.
 type Competitor struct {
+	ID       string `json:",omitempty" hash:"ignore"` // not hashed so hashes match peers predating IDs
 	Name     string
 	AgeClass string
 	Club     string
@@ -0,0 +1,64 @@
+package pusher
+
+import (
+	"log"
+	"net/rpc"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// HeartbeatInterval is how often connected servers are pinged
+var HeartbeatInterval = 10 * time.Second
+
+// HeartbeatStats records the outcome of the last heartbeat. ClockSkew is how far the server's
+// clock is ahead of ours, estimated assuming the round trip was symmetric.
+type HeartbeatStats struct {
+	Time       time.Time
+	RoundTrip  time.Duration
+	ClockSkew  time.Duration
+	ServerHash uint64
+}
+
+// heartbeat pings the server over client until the connection is replaced or fails. A ping
+// that isn't answered in time means the connection is dead, even if TCP hasn't noticed, so the
+// connection is closed and the next submission redials.
+func (m *Server) heartbeat(client *rpc.Client) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if current, _ := m.connection(); current != client {
+			return
+		}
+
+		args := liveo.PingArgs{Sent: time.Now()}
+		var reply liveo.PingReply
+		call := client.Go("Api.Ping", &args, &reply, nil)
+		select {
+		case <-call.Done:
+		case <-time.After(callTimeout):
+			log.Printf("pusher: heartbeat to %s timed out", m.address)
+			client.Close()
+			<-call.Done
+		}
+		if call.Error != nil {
+			log.Printf("pusher: heartbeat to %s failed: %s", m.address, call.Error)
+			client.Close()
+			m.dropClient(client)
+			return
+		}
+
+		received := time.Now()
+		roundTrip := received.Sub(args.Sent)
+		stats := HeartbeatStats{
+			Time:       received,
+			RoundTrip:  roundTrip,
+			ClockSkew:  reply.ServerTime.Sub(args.Sent.Add(roundTrip / 2)),
+			ServerHash: reply.Hash,
+		}
+		m.updateStatus(func(s *Status) {
+			s.Heartbeat = &stats
+		})
+	}
+}
@@ -0,0 +1,349 @@
+// Package pusher manages a connection to a ResultServer, submitting full result sets or deltas
+// to it as results change. It is used by filewatcher and by ResultServers relaying results to
+// other ResultServers.
+package pusher
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"log"
+	"net"
+	"net/rpc"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+
+	"sync"
+	"time"
+)
+
+// A Server is a ResultServer that results are submitted to. Submissions are made one at a time
+// from a goroutine of the server's own; a server that falls behind is sent only the latest set.
+type Server struct {
+	address     string
+	tlsSettings *liveo.TLSSettings
+	// pending holds the latest set submitted and not yet sent
+	pending   chan liveo.ResultDataSet
+	closed    chan struct{}
+	closeOnce sync.Once
+	// lastResultset is the last set the server received, and is only used by the submitting
+	// goroutine
+	lastResultset *liveo.ResultDataSet
+
+	// connMu guards the connection, which heartbeats may drop at any time
+	connMu     sync.Mutex
+	rpcClient  *rpc.Client
+	compressed *liveo.CompressedConn
+
+	// subscriber is set for results servers which connected to us. These can't be
+	// re-dialled so disconnected is called when their connection fails.
+	subscriber   bool
+	disconnected func()
+
+	// statusChanged, if set, is called when the status of the server changes
+	statusChanged func()
+
+	statusMu sync.Mutex
+	status   Status
+}
+
+// Status is the view of a Server reported to users
+type Status struct {
+	Connected bool
+	Protocol  liveo.Handshake
+	LastPush  *PushStats
+	Heartbeat *HeartbeatStats
+}
+
+// PushStats records the size of the last submission to a server. CompressedBytes is zero
+// unless compression was agreed with the server.
+type PushStats struct {
+	Time            time.Time
+	Method          string
+	RawBytes        uint64
+	CompressedBytes uint64
+}
+
+// callTimeout is how long an RPC call may take before the connection is considered dead
+const callTimeout = 5 * time.Second
+
+// NewServer returns a Server which dials address when results are first submitted. Without
+// tlsSettings TLS is used only for port 443. statusChanged, if not nil, is called whenever the
+// server's status changes.
+func NewServer(address string, tlsSettings *liveo.TLSSettings, statusChanged func()) *Server {
+	m := &Server{
+		address:       address,
+		tlsSettings:   tlsSettings,
+		statusChanged: statusChanged,
+		pending:       make(chan liveo.ResultDataSet, 1),
+		closed:        make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// NewSubscriber returns a Server for a results server which connected to us at address over
+// conn, and subscribed using protocol. As with NewServer statusChanged is called when the status
+// changes, and disconnected, if not nil, is called once the connection has failed or been closed.
+func NewSubscriber(address string, conn net.Conn, protocol liveo.Handshake, statusChanged, disconnected func()) *Server {
+	m := &Server{
+		address:       address,
+		subscriber:    true,
+		disconnected:  disconnected,
+		statusChanged: statusChanged,
+		pending:       make(chan liveo.ResultDataSet, 1),
+		closed:        make(chan struct{}),
+	}
+	m.attach(conn, protocol)
+	go m.run()
+	return m
+}
+
+// Address returns the address of the server
+func (m *Server) Address() string {
+	return m.address
+}
+
+// Status returns the current status of the server
+func (m *Server) Status() Status {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	return m.status
+}
+func (m *Server) updateStatus(f func(*Status)) {
+	m.statusMu.Lock()
+	f(&m.status)
+	m.statusMu.Unlock()
+	if m.statusChanged != nil {
+		m.statusChanged()
+	}
+}
+
+// Dial connects to the server. It needn't be called before Submit, but allows connection
+// problems to be reported early.
+func (m *Server) Dial() error {
+	if m.subscriber {
+		return errors.New("subscribed results server has disconnected")
+	}
+	conn, protocol, err := liveo.DialRPC(m.address, liveo.RPCEndpoint, m.tlsSettings)
+	if err != nil {
+		return err
+	}
+	log.Printf("pusher: connected to %s using protocol v%d %v", m.address, protocol.Version, protocol.Capabilities)
+	m.attach(conn, protocol)
+	return nil
+}
+
+// attach starts RPC over an established connection, either dialled or accepted from a
+// subscribing results server.
+func (m *Server) attach(conn net.Conn, protocol liveo.Handshake) {
+	client := rpc.NewClient(conn)
+	compressed, _ := conn.(*liveo.CompressedConn)
+
+	m.connMu.Lock()
+	m.rpcClient = client
+	m.compressed = compressed
+	m.connMu.Unlock()
+
+	m.updateStatus(func(s *Status) {
+		s.Connected = true
+		s.Protocol = protocol
+		s.Heartbeat = nil
+	})
+	if protocol.Has(liveo.CapPing) {
+		go m.heartbeat(client)
+	}
+}
+
+func (m *Server) connection() (*rpc.Client, *liveo.CompressedConn) {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	return m.rpcClient, m.compressed
+}
+
+// dropClient forgets client, if it's still current, so the next submission redials
+func (m *Server) dropClient(client *rpc.Client) {
+	m.connMu.Lock()
+	current := m.rpcClient == client
+	if current {
+		m.rpcClient = nil
+		m.compressed = nil
+	}
+	m.connMu.Unlock()
+
+	if current {
+		m.updateStatus(func(s *Status) {
+			s.Connected = false
+		})
+		m.lostSubscriber()
+	}
+}
+
+// Close closes any connection to the server and stops submitting results to it
+func (m *Server) Close() {
+	m.closeOnce.Do(func() { close(m.closed) })
+	client, _ := m.connection()
+	if client != nil {
+		client.Close()
+		m.dropClient(client)
+	}
+}
+
+// Submit queues rs to be sent to the server, replacing any set not yet sent, and returns without
+// waiting for it to be sent.
+func (m *Server) Submit(rs liveo.ResultDataSet) {
+	for {
+		select {
+		case m.pending <- rs:
+			return
+		default:
+			// drop the stale set
+			select {
+			case <-m.pending:
+			default:
+			}
+		}
+	}
+}
+
+// run sends the sets submitted until the server is closed
+func (m *Server) run() {
+	for {
+		select {
+		case rs := <-m.pending:
+			m.submit(rs)
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+// submit sends rs to the server, as a delta from the last set it successfully received if
+// possible
+func (m *Server) submit(rs liveo.ResultDataSet) {
+	client, compressed := m.connection()
+	if client == nil {
+		dialErr := m.Dial()
+		if dialErr != nil {
+			log.Println("pusher: failed to dial results server:", dialErr)
+			return
+		}
+		log.Println("pusher: successfully re-dialed when making rpc call")
+		client, compressed = m.connection()
+	}
+
+	// what is sent depends on the protocol agreed when dialling
+	protocol := m.Status().Protocol
+	method := "Api.SubmitLatestResults"
+	var args interface{} = &rs
+
+	if m.lastResultset != nil && protocol.Has(liveo.CapDelta) {
+		delta := m.lastResultset.DeltaTo(rs).ExpandChanges(*m.lastResultset, protocol)
+		if !protocol.Has(liveo.CapContentHash) {
+			delta.Old = liveo.LegacyHashResults(m.lastResultset.Results)
+			delta.New = liveo.LegacyHashResults(rs.Results)
+		}
+		method = "Api.SubmitDelta"
+		args = &delta
+	} else if !protocol.Has(liveo.CapContentHash) {
+		legacy := rs
+		legacy.Hash = liveo.LegacyHashResults(rs.Results)
+		args = &legacy
+	}
+
+	var network bytes.Buffer        // Stand-in for a network connection
+	enc := gob.NewEncoder(&network) // Will write to network.
+	enc.Encode(args)
+	log.Printf("pusher: gob encoding of data set is %d bytes.", len(network.Bytes()))
+
+	var rawBefore, compressedBefore uint64
+	if compressed != nil {
+		rawBefore, compressedBefore = compressed.WriteCounts()
+	}
+
+	var reply bool
+	if err := m.call(client, method, args, &reply); err != nil {
+		log.Println("pusher: rpc call error:", err)
+		m.dropClient(client)
+		// possibly we should retry submitting results here but we probably need some
+		// extra work to avoid getting stuck in a loop e.g. where dialling succeeds but
+		// the rpc call fails. For whatever weird reason.
+		return
+	}
+
+	stats := PushStats{
+		Time:     time.Now(),
+		Method:   method,
+		RawBytes: uint64(network.Len()),
+	}
+	if compressed != nil {
+		rawAfter, compressedAfter := compressed.WriteCounts()
+		stats.RawBytes = rawAfter - rawBefore
+		stats.CompressedBytes = compressedAfter - compressedBefore
+		log.Printf("pusher: %s sent %d bytes compressed to %d bytes.", method, stats.RawBytes, stats.CompressedBytes)
+	}
+	m.updateStatus(func(s *Status) {
+		s.LastPush = &stats
+	})
+
+	if !reply && protocol.Has(liveo.CapContentHash) {
+		// only get here on a failed delta submission. the server's results have diverged
+		// from ours so resend just the courses that differ.
+		reply = m.resync(client, rs)
+	}
+
+	if reply {
+		// record this result set as the last successful
+		m.lastResultset = &rs
+	} else {
+		// only get here on a failed delta submission. reset lastresultset
+		m.lastResultset = nil
+	}
+}
+
+// resync brings the server's results into line with rs by resending only the courses whose
+// hashes differ, reporting whether it succeeded
+func (m *Server) resync(client *rpc.Client, rs liveo.ResultDataSet) bool {
+	var hashes liveo.CourseHashesReply
+	if err := m.call(client, "Api.CourseHashes", &liveo.CourseHashesArgs{}, &hashes); err != nil {
+		log.Println("pusher: failed to get course hashes:", err)
+		return false
+	}
+	delta := liveo.ResyncDelta(hashes.Hash, hashes.Courses, rs)
+	log.Printf("pusher: resyncing %d of %d courses with %s", len(delta.Courses.Added), len(rs.Results.Courses), m.address)
+
+	var reply bool
+	if err := m.call(client, "Api.SubmitDelta", &delta, &reply); err != nil {
+		log.Println("pusher: failed to resync results:", err)
+		return false
+	}
+	return reply
+}
+
+// call makes an RPC call over client. Should the call time out the connection is closed, as it
+// is presumed dead.
+func (m *Server) call(client *rpc.Client, method string, args interface{}, reply interface{}) error {
+	call := client.Go(method, args, reply, nil)
+	select {
+	case <-call.Done:
+		// ok
+	case <-time.After(callTimeout):
+		// timed out. close the connection...
+		log.Println("pusher: rpc call timed out")
+		closeErr := client.Close()
+		if closeErr != nil {
+			log.Println("pusher: error closing connection after rpc call timed out:", closeErr)
+			return closeErr
+		}
+		// ...and wait for completion.
+		// this shouldn't block as we just killed the connection.
+		<-call.Done
+	}
+	return call.Error
+}
+
+func (m *Server) lostSubscriber() {
+	if m.subscriber && m.disconnected != nil {
+		m.disconnected()
+	}
+}
@@ -0,0 +1,175 @@
+package pusher
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// testReceiver is the API of a results server, recording the sets it receives. Calls block
+// until release is closed, if it's set.
+type testReceiver struct {
+	release chan struct{}
+
+	mu       sync.Mutex
+	received []liveo.ResultDataSet
+}
+
+func (a *testReceiver) SubmitLatestResults(r *liveo.ResultDataSet, res *bool) error {
+	if a.release != nil {
+		<-a.release
+	}
+	a.mu.Lock()
+	a.received = append(a.received, *r)
+	a.mu.Unlock()
+	*res = true
+	return nil
+}
+
+func (a *testReceiver) SubmitDelta(r *liveo.ResultDelta, res *bool) error {
+	*res = false
+	return nil
+}
+
+func (a *testReceiver) sets() []liveo.ResultDataSet {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]liveo.ResultDataSet(nil), a.received...)
+}
+
+// testProtocol has the receiver sent full sets, identified by content hash
+var testProtocol = liveo.Handshake{Version: liveo.ProtocolVersion, Capabilities: []string{liveo.CapContentHash}}
+
+// servePipe serves api at one end of a pipe, returning the other end and a channel closed
+// once serving ends
+func servePipe(t *testing.T, api *testReceiver) (net.Conn, net.Conn, chan struct{}) {
+	t.Helper()
+	server := rpc.NewServer()
+	if err := server.RegisterName("Api", api); err != nil {
+		t.Fatal(err)
+	}
+	serverEnd, clientEnd := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(serverEnd)
+		close(done)
+	}()
+	return serverEnd, clientEnd, done
+}
+
+func testResults(title string) liveo.ResultDataSet {
+	r := liveo.Results{Title: title}
+	return liveo.ResultDataSet{Results: r, Hash: liveo.HashResults(r)}
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubmitCoalesces(t *testing.T) {
+	api := &testReceiver{release: make(chan struct{})}
+	_, conn, _ := servePipe(t, api)
+	var statusChanges int
+	var statusMu sync.Mutex
+	m := NewSubscriber("pipe", conn, testProtocol, func() {
+		statusMu.Lock()
+		statusChanges++
+		statusMu.Unlock()
+	}, nil)
+	defer m.Close()
+
+	// the first set is taken by the submitting goroutine, which then waits on the receiver
+	// while later sets replace each other
+	m.Submit(testResults("0"))
+	waitFor(t, "first submission", func() bool { return len(m.pending) == 0 })
+	for i := 1; i <= 100; i++ {
+		m.Submit(testResults(fmt.Sprint(i)))
+	}
+	last := testResults("latest")
+	m.Submit(last)
+	close(api.release)
+
+	waitFor(t, "latest set", func() bool {
+		sets := api.sets()
+		return len(sets) > 0 && sets[len(sets)-1].Hash == last.Hash
+	})
+	if sets := api.sets(); len(sets) != 2 {
+		t.Errorf("receiver was sent %d sets, want the first and the latest", len(sets))
+	}
+	if !m.Status().Connected || m.Status().LastPush == nil {
+		t.Errorf("status %+v, want connected with a push", m.Status())
+	}
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if statusChanges == 0 {
+		t.Error("status changes weren't reported")
+	}
+}
+
+func TestCloseStopsSubmitting(t *testing.T) {
+	api := &testReceiver{}
+	_, conn, served := servePipe(t, api)
+	disconnected := make(chan struct{}, 1)
+	m := NewSubscriber("pipe", conn, testProtocol, nil, func() { disconnected <- struct{}{} })
+
+	m.Submit(testResults("before"))
+	waitFor(t, "submission", func() bool { return len(api.sets()) == 1 })
+
+	m.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection wasn't closed")
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing a subscriber didn't report it disconnected")
+	}
+	if m.Status().Connected {
+		t.Error("closed server still connected")
+	}
+
+	m.Submit(testResults("after"))
+	m.Close()
+	time.Sleep(10 * time.Millisecond)
+	if sets := api.sets(); len(sets) != 1 {
+		t.Errorf("receiver was sent %d sets, want 1 from before closing", len(sets))
+	}
+}
+
+func TestSubscriberDisconnected(t *testing.T) {
+	api := &testReceiver{}
+	serverEnd, conn, _ := servePipe(t, api)
+	disconnected := make(chan struct{}, 1)
+	m := NewSubscriber("pipe", conn, testProtocol, nil, func() { disconnected <- struct{}{} })
+	defer m.Close()
+
+	// the subscriber goes away; it's noticed on the next submission
+	serverEnd.Close()
+	m.Submit(testResults("lost"))
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed connection wasn't reported")
+	}
+	if m.Status().Connected {
+		t.Error("disconnected subscriber still connected")
+	}
+	if err := m.Dial(); err == nil {
+		t.Error("subscriber was redialled")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fivegreenapples/live-o-results/feed"
+
+	"gopkg.in/igm/sockjs-go.v2/sockjs"
+)
+
+// EventsEndpoint serves recent events as JSON. Events since a sequence number are requested
+// with ?since=<seq>.
+const EventsEndpoint = "/events/v1"
+
+// EventsSocketPrefix is the sockjs endpoint streaming events as they happen
+const EventsSocketPrefix = "/events/sockjs"
+
+// eventLogSize is how many recent events are kept for clients catching up
+const eventLogSize = 500
+
+// eventStream numbers events derived from result changes, keeps the most recent and sends them
+// to watching sockjs sessions
+type eventStream struct {
+	mu       sync.Mutex
+	log      []feed.Event
+	lastSeq  int64
+	watchers map[string]func([]feed.Event)
+}
+
+type eventsResponse struct {
+	Events []feed.Event `json:"events"`
+	Latest int64        `json:"latest"`
+}
+
+// newEventStream returns an eventStream numbering events from lastSeq+1
+func newEventStream(lastSeq int64) *eventStream {
+	return &eventStream{
+		lastSeq:  lastSeq,
+		watchers: map[string]func([]feed.Event){},
+	}
+}
+
+// publish numbers events and sends them to watchers. Events are sent once the stream is
+// unlocked, so that a slow watcher holds up neither publishing nor other clients catching up.
+// It must not be called concurrently.
+func (s *eventStream) publish(events []feed.Event) {
+	if len(events) == 0 {
+		return
+	}
+	s.mu.Lock()
+	for i := range events {
+		s.lastSeq++
+		events[i].Seq = s.lastSeq
+	}
+	s.log = append(s.log, events...)
+	if len(s.log) > eventLogSize {
+		s.log = append([]feed.Event(nil), s.log[len(s.log)-eventLogSize:]...)
+	}
+	watchers := make([]func([]feed.Event), 0, len(s.watchers))
+	for _, watcher := range s.watchers {
+		watchers = append(watchers, watcher)
+	}
+	s.mu.Unlock()
+
+	for _, watcher := range watchers {
+		watcher(events)
+	}
+}
+
+// since returns the kept events after seq, and the sequence number of the latest event
+func (s *eventStream) since(seq int64) ([]feed.Event, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first := len(s.log)
+	for first > 0 && s.log[first-1].Seq > seq {
+		first--
+	}
+	return append([]feed.Event{}, s.log[first:]...), s.lastSeq
+}
+
+// latestSeq returns the sequence number of the latest event
+func (s *eventStream) latestSeq() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeq
+}
+
+func (s *eventStream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var seq int64
+	if sinceParam := req.URL.Query().Get("since"); sinceParam != "" {
+		var err error
+		if seq, err = strconv.ParseInt(sinceParam, 10, 64); err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+	events, latest := s.since(seq)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eventsResponse{Events: events, Latest: latest})
+}
+
+// serveSession streams events to a sockjs session as "ResultEvents" events. The session may
+// send "Since <seq>" to receive the kept events after seq, which may repeat events already
+// streamed, so clients should ignore those with a seq they have seen.
+func (s *eventStream) serveSession(session sockjs.Session) {
+	log.Println("Events session started", session.ID())
+
+	send := func(events []feed.Event) {
+		ev := struct {
+			Type string
+			Msg  struct {
+				Name string
+				Data interface{}
+			}
+		}{Type: "Event"}
+		ev.Msg.Name = "ResultEvents"
+		ev.Msg.Data = events
+		evMsg, _ := json.Marshal(&ev)
+		session.Send(string(evMsg))
+	}
+
+	s.mu.Lock()
+	s.watchers[session.ID()] = send
+	s.mu.Unlock()
+
+	for {
+		msg, err := session.Recv()
+		if err != nil {
+			break
+		}
+		if strings.HasPrefix(msg, "Since ") {
+			seq, _ := strconv.ParseInt(strings.TrimPrefix(msg, "Since "), 10, 64)
+			if events, _ := s.since(seq); len(events) > 0 {
+				send(events)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.watchers, session.ID())
+	s.mu.Unlock()
+	log.Println("Events session ended", session.ID())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// historySize is how many recent result sets a viewer can fall behind by and still catch up
+// with a single delta
+const historySize = 32
+
+// resultHistory is a ring buffer of the deltas between recent result sets
+type resultHistory struct {
+	mu     sync.Mutex
+	deltas [historySize]liveo.ResultDelta
+	next   int
+	count  int
+}
+
+// add records the delta to the latest result set, which must follow on from the last delta added
+func (h *resultHistory) add(d liveo.ResultDelta) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deltas[h.next] = d
+	h.next = (h.next + 1) % historySize
+	if h.count < historySize {
+		h.count++
+	}
+}
+
+// all returns the deltas held, oldest first
+func (h *resultHistory) all() []liveo.ResultDelta {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	deltas := make([]liveo.ResultDelta, 0, h.count)
+	for i := 0; i < h.count; i++ {
+		deltas = append(deltas, h.deltas[(h.next+historySize-h.count+i)%historySize])
+	}
+	return deltas
+}
+
+// deltaSince returns a single delta from the recent result set identified by matches to the
+// latest, if that set is still in the history
+func (h *resultHistory) deltaSince(matches func(hash uint64) bool) (liveo.ResultDelta, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return liveo.ResultDelta{}, false
+	}
+	latest := h.deltas[(h.next+historySize-1)%historySize]
+	if matches(latest.New) {
+		return liveo.ResultDelta{Old: latest.New, New: latest.New}, true
+	}
+
+	// Results may return to an earlier set, so start from the latest match
+	start := -1
+	for i := 0; i < h.count; i++ {
+		index := (h.next + historySize - h.count + i) % historySize
+		if matches(h.deltas[index].Old) {
+			start = i
+		}
+	}
+	if start < 0 {
+		return liveo.ResultDelta{}, false
+	}
+
+	composed := h.deltas[(h.next+historySize-h.count+start)%historySize]
+	for i := start + 1; i < h.count; i++ {
+		var err error
+		composed, err = composed.Compose(h.deltas[(h.next+historySize-h.count+i)%historySize])
+		if err != nil {
+			return liveo.ResultDelta{}, false
+		}
+	}
+	return composed, true
+}
+
+// viewerHash returns a func matching hashes against one sent by a viewer. Viewers of the feed
+// format have the exact hash, but legacy viewers hold it as a Javascript number which can't hold
+// every uint64, so hashes also match at the precision such a viewer has.
+func viewerHash(hash string) func(uint64) bool {
+	f, err := strconv.ParseFloat(hash, 64)
+	if err != nil || f == 0 {
+		return func(uint64) bool { return false }
+	}
+	exact, _ := strconv.ParseUint(hash, 10, 64)
+	return func(h uint64) bool {
+		return h == exact || float64(h) == f
+	}
+}
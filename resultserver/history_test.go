@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// historyOf returns a history of the changes between sets, in order
+func historyOf(sets ...liveo.ResultDataSet) *resultHistory {
+	h := &resultHistory{}
+	for i := 1; i < len(sets); i++ {
+		h.add(sets[i-1].DeltaTo(sets[i]))
+	}
+	return h
+}
+
+// checkCatchUp checks that the delta given for a viewer with from takes it to want
+func checkCatchUp(t *testing.T, h *resultHistory, from, want liveo.ResultDataSet) {
+	t.Helper()
+	delta, ok := h.deltaSince(viewerHash(fmt.Sprint(from.Hash)))
+	if !ok {
+		t.Fatalf("no delta from %d", from.Hash)
+	}
+	got, err := from.Apply(delta)
+	if err != nil {
+		t.Fatalf("delta from %d doesn't apply: %s", from.Hash, err)
+	}
+	if got.Hash != want.Hash {
+		t.Fatalf("delta from %d gives %d, want %d", from.Hash, got.Hash, want.Hash)
+	}
+}
+
+func TestDeltaSinceCurrent(t *testing.T) {
+	A, B := hubTestResults(0), hubTestResults(1)
+	h := historyOf(A, B)
+	delta, ok := h.deltaSince(viewerHash(fmt.Sprint(B.Hash)))
+	if !ok || delta.Old != B.Hash || delta.New != B.Hash {
+		t.Errorf("delta %d to %d (%v) for an up to date viewer, want an empty delta at %d", delta.Old, delta.New, ok, B.Hash)
+	}
+	checkCatchUp(t, h, A, B)
+}
+
+func TestDeltaSinceRepeatedResults(t *testing.T) {
+	// a result is added and then withdrawn, so A is seen twice
+	A, B, C := hubTestResults(0), hubTestResults(1), receiverTestResults(7*time.Minute)
+	h := historyOf(A, B, A, C)
+
+	checkCatchUp(t, h, A, C)
+	checkCatchUp(t, h, B, C)
+
+	// the delta is from the latest A, so it's the single last change rather than a composition
+	delta, _ := h.deltaSince(viewerHash(fmt.Sprint(A.Hash)))
+	if want := A.DeltaTo(C); !reflect.DeepEqual(delta, want) {
+		t.Errorf("delta from A is\n%+v\nwant\n%+v", delta, want)
+	}
+}
+
+func TestDeltaSinceExpired(t *testing.T) {
+	sets := make([]liveo.ResultDataSet, historySize+2)
+	for i := range sets {
+		sets[i] = hubTestResults(i)
+	}
+	h := historyOf(sets...)
+
+	if _, ok := h.deltaSince(viewerHash(fmt.Sprint(sets[0].Hash))); ok {
+		t.Error("delta given from results which have left the history")
+	}
+	checkCatchUp(t, h, sets[1], sets[len(sets)-1])
+	if _, ok := h.deltaSince(viewerHash("12345")); ok {
+		t.Error("delta given from unknown results")
+	}
+	if _, ok := (&resultHistory{}).deltaSince(viewerHash(fmt.Sprint(sets[0].Hash))); ok {
+		t.Error("delta given from an empty history")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/export"
+	"github.com/fivegreenapples/live-o-results/feed"
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// HTMLPagesPrefix is the URI path under which results are served as plain HTML
+const HTMLPagesPrefix = "/results/"
+
+// htmlRefresh is how often, in seconds, HTML pages reload themselves
+const htmlRefresh = 30
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var resultsTemplate = template.Must(template.New("results.html").Funcs(template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"formatTime": formatTime,
+}).ParseFS(templateFiles, "templates/results.html"))
+
+// htmlPages renders the current results as plain HTML which needs neither Javascript nor
+// websockets, for old phones, search engines and link previews:
+//
+//	/results/                all courses
+//	/results/course/{title}  a single course
+//	/results/club/{club}     the competitors of a club, by course
+//
+// Pages reload themselves every htmlRefresh seconds, and carry a weak ETag of the results hash
+// so that reloads are answered with 304 until the results change.
+type htmlPages struct {
+	current func() liveo.ResultDataSet
+}
+
+type htmlPage struct {
+	Title       string
+	Heading     string
+	Description string
+	AllCourses  []feed.Course
+	Courses     []feed.Course
+	Refresh     int
+	Updated     string
+}
+
+func (p *htmlPages) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rs := p.current()
+	results := feed.FromResults(rs)
+	page := htmlPage{
+		Title:      results.Title,
+		AllCourses: results.Courses,
+		Refresh:    htmlRefresh,
+		Updated:    time.Now().Format("15:04:05"),
+	}
+	if page.Title == "" {
+		page.Title = "Live Results"
+	}
+	status := http.StatusOK
+	path := strings.TrimPrefix(req.URL.Path, HTMLPagesPrefix)
+	switch {
+	case path == "":
+		page.Courses = results.Courses
+		page.Description = describeCourses(results.Courses)
+	case strings.HasPrefix(path, "course/"):
+		title := strings.TrimPrefix(path, "course/")
+		for _, c := range results.Courses {
+			if strings.EqualFold(c.Title, title) {
+				page.Heading = c.Title
+				page.Courses = []feed.Course{c}
+				page.Description = describeCourse(c)
+			}
+		}
+		if page.Heading == "" {
+			status = http.StatusNotFound
+			page.Heading = "No course " + title
+		}
+	case strings.HasPrefix(path, "club/"):
+		club := strings.TrimSpace(strings.TrimPrefix(path, "club/"))
+		page.Heading = club
+		page.Courses = filterCompetitors(rs, func(c feed.Competitor) bool {
+			return strings.EqualFold(strings.TrimSpace(c.Club), club)
+		}).Courses
+		page.Description = fmt.Sprintf("Results for %s: %s", club, describeCourses(page.Courses))
+		if len(page.Courses) == 0 {
+			status = http.StatusNotFound
+		}
+	default:
+		status = http.StatusNotFound
+		page.Heading = "Page not found"
+	}
+
+	// the page is resolved first so that unknown pages are answered 404 whatever the ETag
+	if status == http.StatusOK {
+		etag := `W/"` + feed.FormatHash(rs.Hash) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	var rendered bytes.Buffer
+	if err := resultsTemplate.Execute(&rendered, page); err != nil {
+		log.Println("Failed to render results page:", err)
+		http.Error(w, "failed to render results", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, req, status, "text/html; charset=utf-8", rendered.Bytes())
+}
+
+// describeCourses summarises courses for link previews
+func describeCourses(courses []feed.Course) string {
+	competitors := 0
+	for _, c := range courses {
+		competitors += len(c.Competitors)
+	}
+	return fmt.Sprintf("%d competitors on %d courses", competitors, len(courses))
+}
+
+// describeCourse summarises a course for link previews, with its leader if it has one
+func describeCourse(c feed.Course) string {
+	description := fmt.Sprintf("%d competitors", len(c.Competitors))
+	for _, leader := range c.Competitors {
+		if leader.Position == 1 {
+			return description + fmt.Sprintf(", led by %s in %s", leader.Name, formatTime(leader.TimeMs))
+		}
+	}
+	return description
+}
+
+// formatTime formats a time in milliseconds as m:ss, or h:mm:ss from an hour
+func formatTime(ms int64) string {
+	return export.FormatTime(time.Duration(ms) * time.Millisecond)
+}
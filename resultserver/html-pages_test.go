@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fivegreenapples/live-o-results/feed"
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+func TestHTMLPagesStatus(t *testing.T) {
+	rs := restTestResults()
+	pages := &htmlPages{current: func() liveo.ResultDataSet { return rs }}
+	current := `W/"` + feed.FormatHash(rs.Hash) + `"`
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/results/", http.StatusNotModified},
+		{"/results/course/Blue", http.StatusNotModified},
+		{"/results/course/Red", http.StatusNotFound},
+		{"/results/club/Alpha", http.StatusNotModified},
+		{"/results/club/Beta", http.StatusNotFound},
+		{"/results/unknown", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("If-None-Match", current)
+		w := httptest.NewRecorder()
+		pages.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("GET %s: status %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+)
+
+// sessionQueueSize is how many updates may wait to be sent to a viewer. A viewer falling further
+// behind misses updates and is caught up once it has sent those queued.
+const sessionQueueSize = 32
+
+// hub fans changes of results out to viewers without blocking. Each update is encoded once,
+// then queued for every subscription; a viewer's own goroutine sends what is queued for it.
+type hub struct {
+	mu            sync.RWMutex
+	subscriptions map[string]*subscription
+}
+
+// subscription is a viewer's queue of updates
+type subscription struct {
+	id      string
+	updates chan viewerUpdate
+	dropped int32
+}
+
+func newHub() *hub {
+	return &hub{
+		subscriptions: map[string]*subscription{},
+	}
+}
+
+// join returns a subscription to updates for the viewer identified by id
+func (h *hub) join(id string) *subscription {
+	s := &subscription{
+		id:      id,
+		updates: make(chan viewerUpdate, sessionQueueSize),
+	}
+	h.mu.Lock()
+	h.subscriptions[id] = s
+	h.mu.Unlock()
+	return s
+}
+
+// leave ends a subscription, closing its queue
+func (h *hub) leave(s *subscription) {
+	h.mu.Lock()
+	delete(h.subscriptions, s.id)
+	h.mu.Unlock()
+	close(s.updates)
+}
+
+// broadcast encodes u for viewers and queues it for every subscription. Subscriptions with a
+// full queue drop it.
+func (h *hub) broadcast(u viewerUpdate) {
+	u.deltaJSON, _ = json.Marshal(u.delta)
+	u.feedJSON, _ = json.Marshal(u.feed)
+	u.deltaEvent = socketEvent("NewDelta", u.deltaJSON)
+	u.feedEvent = socketEvent("Delta", u.feedJSON)
+	u.views = &viewCache{views: map[string]*cachedView{}}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, s := range h.subscriptions {
+		select {
+		case s.updates <- u:
+		default:
+			atomic.StoreInt32(&s.dropped, 1)
+		}
+	}
+}
+
+// count returns the number of subscriptions
+func (h *hub) count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscriptions)
+}
+
+// lagged reports whether updates were dropped since it was last called. Subscribers should
+// check once they have emptied the queue, and if so catch up with the current results.
+func (s *subscription) lagged() bool {
+	return atomic.SwapInt32(&s.dropped, 0) == 1
+}
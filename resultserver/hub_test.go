@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/feed"
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// hubUpdate returns the update broadcast when results change from previous to results
+func hubUpdate(previous, results liveo.ResultDataSet) viewerUpdate {
+	delta := previous.DeltaTo(results)
+	return viewerUpdate{
+		delta:    delta,
+		feed:     feed.FromDelta(delta, results),
+		previous: previous,
+		results:  results,
+	}
+}
+
+// hubTestResults returns the n'th of a sequence of result sets, each adding a finisher
+func hubTestResults(n int) liveo.ResultDataSet {
+	times := make([]time.Duration, n+1)
+	for i := range times {
+		times[i] = time.Duration(i+1) * time.Minute
+	}
+	return receiverTestResults(times...)
+}
+
+// testViewerClient applies what a viewer is sent, as the results page does
+type testViewerClient struct {
+	t       *testing.T
+	results liveo.ResultDataSet
+}
+
+func (c *testViewerClient) receive(msg string) {
+	var event struct {
+		Msg struct {
+			Name string
+			Data json.RawMessage
+		}
+	}
+	if err := json.Unmarshal([]byte(msg), &event); err != nil {
+		c.t.Fatal(err)
+	}
+	switch event.Msg.Name {
+	case "NewResults":
+		c.results = liveo.ResultDataSet{}
+		if err := json.Unmarshal(event.Msg.Data, &c.results); err != nil {
+			c.t.Fatal(err)
+		}
+	case "NewDelta":
+		var delta liveo.ResultDelta
+		if err := json.Unmarshal(event.Msg.Data, &delta); err != nil {
+			c.t.Fatal(err)
+		}
+		next, err := c.results.Apply(delta)
+		if err != nil {
+			c.t.Fatalf("client at %d can't apply delta %d to %d: %v", c.results.Hash, delta.Old, delta.New, err)
+		}
+		c.results = next
+	default:
+		c.t.Fatalf("unexpected event %s", event.Msg.Name)
+	}
+}
+
+func TestBroadcastDoesNotBlock(t *testing.T) {
+	const subscribers = 5000
+	h := newHub()
+	subs := make([]*subscription, subscribers)
+	for i := range subs {
+		subs[i] = h.join(fmt.Sprint(i))
+	}
+	full := subs[0]
+	for i := 0; i < sessionQueueSize; i++ {
+		full.updates <- viewerUpdate{}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.broadcast(hubUpdate(hubTestResults(0), hubTestResults(1)))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("broadcast blocked on a full queue")
+	}
+
+	if !full.lagged() {
+		t.Error("subscription with a full queue isn't lagged")
+	}
+	for _, s := range subs[1:] {
+		if len(s.updates) != 1 {
+			t.Fatalf("subscription %s has %d updates queued, want 1", s.id, len(s.updates))
+		}
+		if s.lagged() {
+			t.Fatalf("subscription %s lagged", s.id)
+		}
+	}
+}
+
+func TestLaggedViewerResyncs(t *testing.T) {
+	h := newHub()
+	s := h.join("viewer")
+	client := &testViewerClient{t: t}
+	v := newViewer(client.receive, newResultsCache())
+
+	current := hubTestResults(0)
+	v.requestResults(current, liveo.ResultDelta{}, false, "")
+	if client.results.Hash != current.Hash {
+		t.Fatalf("client has %d, want %d", client.results.Hash, current.Hash)
+	}
+
+	// the viewer's goroutine stalls while more updates are broadcast than its queue holds
+	for i := 1; i <= 2*sessionQueueSize; i++ {
+		next := hubTestResults(i)
+		h.broadcast(hubUpdate(current, next))
+		current = next
+	}
+	for len(s.updates) > 0 {
+		v.update(<-s.updates)
+	}
+	if client.results.Hash == current.Hash {
+		t.Fatal("client is current without missing updates")
+	}
+	if !s.lagged() {
+		t.Fatal("subscription that dropped updates isn't lagged")
+	}
+	v.resync(current)
+	if client.results.Hash != current.Hash {
+		t.Errorf("client has %d after resync, want %d", client.results.Hash, current.Hash)
+	}
+	if s.lagged() {
+		t.Error("subscription still lagged after it was checked")
+	}
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	for _, sessions := range []int{1000, 10000} {
+		b.Run(fmt.Sprint(sessions), func(b *testing.B) {
+			h := newHub()
+			encoded := newResultsCache()
+			current := hubTestResults(0)
+			var wg sync.WaitGroup
+			for i := 0; i < sessions; i++ {
+				s := h.join(fmt.Sprint(i))
+				v := newViewer(func(string) {}, encoded)
+				v.requestResults(current, liveo.ResultDelta{}, false, "")
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for u := range s.updates {
+						v.update(u)
+					}
+					if s.lagged() {
+						v.resync(current)
+					}
+				}()
+			}
+			updates := make([]viewerUpdate, 16)
+			for i := range updates {
+				next := hubTestResults(i + 1)
+				updates[i] = hubUpdate(current, next)
+				current = next
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.broadcast(updates[i%len(updates)])
+			}
+			h.mu.RLock()
+			subs := make([]*subscription, 0, len(h.subscriptions))
+			for _, s := range h.subscriptions {
+				subs = append(subs, s)
+			}
+			h.mu.RUnlock()
+			for _, s := range subs {
+				h.leave(s)
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/igm/sockjs-go.v2/sockjs"
+)
+
+// LimitsStatusEndpoint is the URI path reporting viewer sessions and how often limits were hit
+const LimitsStatusEndpoint = "/limits/status"
+
+// limitLogInterval is how often hitting a limit is logged, so that a flood of requests doesn't
+// become a flood of log lines
+const limitLogInterval = 10 * time.Second
+
+// limits protects the server from more viewers, or more requests, than it can serve. It caps
+// concurrent sessions (sockjs and event streams) overall and per client address, and rate limits
+// requests for full results and HTTP API calls per client address with token buckets. A zero
+// limit or rate is no limit.
+type limits struct {
+	maxSessions      int
+	maxSessionsPerIP int
+	fullSets         rateLimit
+	api              rateLimit
+	// trustProxy takes client addresses from X-Forwarded-For, as set by a reverse proxy
+	trustProxy bool
+	// clock returns the time buckets are refilled to, and is replaced by tests
+	clock func() time.Time
+
+	mu           sync.Mutex
+	sessions     int
+	sessionsByIP map[string]int
+	buckets      map[string]*tokenBucket
+	lastPrune    time.Time
+	lastLogged   map[string]time.Time
+	stats        limitStats
+}
+
+// rateLimit allows PerSecond requests a second on average, in bursts of up to Burst. Requests
+// beyond it may wait up to MaxWait for their turn.
+type rateLimit struct {
+	PerSecond float64
+	Burst     float64
+	MaxWait   time.Duration
+}
+
+// limitStats counts the times limits were hit
+type limitStats struct {
+	SessionsRejected      uint64
+	SessionsRejectedPerIP uint64
+	FullSetsDelayed       uint64
+	FullSetsRejected      uint64
+	APIRejected           uint64
+}
+
+type limitsStatus struct {
+	Sessions         int
+	MaxSessions      int
+	MaxSessionsPerIP int
+	FullSets         rateLimit
+	API              rateLimit
+	limitStats
+}
+
+func newLimits(maxSessions, maxSessionsPerIP int, fullSets, api rateLimit, trustProxy bool) *limits {
+	return &limits{
+		maxSessions:      maxSessions,
+		maxSessionsPerIP: maxSessionsPerIP,
+		fullSets:         fullSets,
+		api:              api,
+		trustProxy:       trustProxy,
+		clock:            time.Now,
+		sessionsByIP:     map[string]int{},
+		buckets:          map[string]*tokenBucket{},
+		lastLogged:       map[string]time.Time{},
+	}
+}
+
+// clientIP returns the address of the client making req
+func (l *limits) clientIP(req *http.Request) string {
+	if l.trustProxy {
+		// the proxy appends the address it saw, so only the last entry is to be trusted
+		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+			entries := strings.Split(forwarded, ",")
+			return strings.TrimSpace(entries[len(entries)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// openSession reports whether a client at ip may start a session, counting it if so. Each
+// session opened must be closed with closeSession.
+func (l *limits) openSession(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSessions > 0 && l.sessions >= l.maxSessions {
+		l.stats.SessionsRejected++
+		l.logLimit("sessions", "Rejecting session from %s: %d sessions open", ip, l.sessions)
+		return false
+	}
+	if l.maxSessionsPerIP > 0 && l.sessionsByIP[ip] >= l.maxSessionsPerIP {
+		l.stats.SessionsRejectedPerIP++
+		l.logLimit("sessions "+ip, "Rejecting session from %s: %d sessions open from there", ip, l.sessionsByIP[ip])
+		return false
+	}
+	l.sessions++
+	l.sessionsByIP[ip]++
+	return true
+}
+
+func (l *limits) closeSession(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sessions--
+	if l.sessionsByIP[ip]--; l.sessionsByIP[ip] <= 0 {
+		delete(l.sessionsByIP, ip)
+	}
+}
+
+// limitSessions returns serve wrapped so that sessions beyond the limits are closed at once
+func (l *limits) limitSessions(serve func(sockjs.Session)) func(sockjs.Session) {
+	return func(session sockjs.Session) {
+		ip := l.clientIP(session.Request())
+		if !l.openSession(ip) {
+			session.Close(4029, "too many sessions")
+			return
+		}
+		defer l.closeSession(ip)
+		serve(session)
+	}
+}
+
+// waitFullSet waits for a client at ip to be allowed to request full results, reporting false
+// if it would have to wait too long
+func (l *limits) waitFullSet(ip string) bool {
+	delay, ok := l.reserve("full "+ip, l.fullSets)
+	l.mu.Lock()
+	if !ok {
+		l.stats.FullSetsRejected++
+		l.logLimit("full "+ip, "Rejecting requests for results from %s", ip)
+	} else if delay > 0 {
+		l.stats.FullSetsDelayed++
+	}
+	l.mu.Unlock()
+	if ok {
+		time.Sleep(delay)
+	}
+	return ok
+}
+
+// limitAPI returns h wrapped so that clients calling it too often are refused with 429
+func (l *limits) limitAPI(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip := l.clientIP(req)
+		if _, ok := l.reserve("api "+ip, l.api); !ok {
+			l.mu.Lock()
+			l.stats.APIRejected++
+			l.logLimit("api "+ip, "Rejecting API requests from %s", ip)
+			l.mu.Unlock()
+			w.Header().Set("Retry-After", strconv.Itoa(int(1/l.api.PerSecond)+1))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// reserve takes a token from the bucket for key, returning how long to wait before using it
+func (l *limits) reserve(key string, limit rateLimit) (time.Duration, bool) {
+	if limit.PerSecond <= 0 {
+		return 0, true
+	}
+	now := l.clock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: limit.Burst, last: now, limit: limit}
+		l.buckets[key] = bucket
+	}
+	return bucket.reserve(now)
+}
+
+// prune forgets buckets which have refilled, at most once a minute
+func (l *limits) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for key, bucket := range l.buckets {
+		if bucket.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+	for key, logged := range l.lastLogged {
+		if now.Sub(logged) > limitLogInterval {
+			delete(l.lastLogged, key)
+		}
+	}
+}
+
+// logLimit logs that a limit was hit, unless it was logged recently. l.mu must be held.
+func (l *limits) logLimit(key string, format string, args ...interface{}) {
+	now := time.Now()
+	if now.Sub(l.lastLogged[key]) < limitLogInterval {
+		return
+	}
+	l.lastLogged[key] = now
+	log.Printf(format, args...)
+}
+
+func (l *limits) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !authorisedBySecret(req) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	l.mu.Lock()
+	status := limitsStatus{
+		Sessions:         l.sessions,
+		MaxSessions:      l.maxSessions,
+		MaxSessionsPerIP: l.maxSessionsPerIP,
+		FullSets:         l.fullSets,
+		API:              l.api,
+		limitStats:       l.stats,
+	}
+	l.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// tokenBucket holds up to limit.Burst tokens, refilled at limit.PerSecond. Tokens may be
+// borrowed against the refill for up to limit.MaxWait.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  rateLimit
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.PerSecond
+	if b.tokens > b.limit.Burst {
+		b.tokens = b.limit.Burst
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) reserve(now time.Time) (time.Duration, bool) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	delay := time.Duration((1 - b.tokens) / b.limit.PerSecond * float64(time.Second))
+	if delay > b.limit.MaxWait {
+		return 0, false
+	}
+	b.tokens--
+	return delay, true
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.PerSecond >= b.limit.Burst
+}
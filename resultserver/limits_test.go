@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testClock is a clock which moves only when told to
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) time() time.Time         { return c.now }
+func (c *testClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestRateLimit(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, time.October, 18, 10, 0, 0, 0, time.UTC)}
+	l := newLimits(0, 0, rateLimit{PerSecond: 1, Burst: 3, MaxWait: 2 * time.Second}, rateLimit{}, false)
+	l.clock = clock.time
+
+	expect := func(what string, wantDelay time.Duration, wantOK bool) {
+		t.Helper()
+		delay, ok := l.reserve("full 192.0.2.1", l.fullSets)
+		if delay != wantDelay || ok != wantOK {
+			t.Errorf("%s: delay %s %v, want %s %v", what, delay, ok, wantDelay, wantOK)
+		}
+	}
+
+	// a burst is allowed, then requests wait their turn for up to MaxWait
+	for i := 0; i < 3; i++ {
+		expect("burst", 0, true)
+	}
+	expect("first beyond the burst", time.Second, true)
+	expect("second beyond the burst", 2*time.Second, true)
+	expect("beyond MaxWait", 0, false)
+
+	// the bucket refills at PerSecond, to no more than the burst
+	clock.advance(3 * time.Second)
+	expect("after refilling a token", 0, true)
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		expect("burst after an hour", 0, true)
+	}
+	expect("beyond the burst after an hour", time.Second, true)
+
+	// other clients have buckets of their own
+	if delay, ok := l.reserve("full 192.0.2.2", l.fullSets); delay != 0 || !ok {
+		t.Errorf("another client: delay %s %v, want no delay", delay, ok)
+	}
+	// and a zero rate is no limit
+	for i := 0; i < 100; i++ {
+		if delay, ok := l.reserve("api 192.0.2.1", l.api); delay != 0 || !ok {
+			t.Fatalf("unlimited: delay %s %v, want no delay", delay, ok)
+		}
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	l := newLimits(3, 2, rateLimit{}, rateLimit{}, false)
+	for _, step := range []struct {
+		open, close string
+		want        bool
+	}{
+		{open: "a", want: true},
+		{open: "a", want: true},
+		{open: "a", want: false}, // per address
+		{open: "b", want: true},
+		{open: "c", want: false}, // overall
+		{close: "a"},
+		{open: "c", want: true},
+		{open: "a", want: false}, // overall
+		{close: "b"},
+		{open: "a", want: true},
+	} {
+		if step.close != "" {
+			l.closeSession(step.close)
+			continue
+		}
+		if got := l.openSession(step.open); got != step.want {
+			t.Fatalf("opening a session for %s: %v, want %v (sessions %v)", step.open, got, step.want, l.sessionsByIP)
+		}
+	}
+	if l.sessions != 3 || l.sessionsByIP["a"] != 2 || l.sessionsByIP["c"] != 1 {
+		t.Errorf("sessions %d %v, want 2 for a and 1 for c", l.sessions, l.sessionsByIP)
+	}
+	if l.stats.SessionsRejected != 2 || l.stats.SessionsRejectedPerIP != 1 {
+		t.Errorf("stats %+v, want 2 rejected overall and 1 per address", l.stats)
+	}
+	for _, ip := range []string{"a", "a", "c"} {
+		l.closeSession(ip)
+	}
+	if l.sessions != 0 || len(l.sessionsByIP) != 0 {
+		t.Errorf("sessions %d %v after closing them all", l.sessions, l.sessionsByIP)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	for _, test := range []struct {
+		trustProxy bool
+		forwarded  string
+		want       string
+	}{
+		{false, "", "192.0.2.1"},
+		{false, "198.51.100.7", "192.0.2.1"},
+		{true, "", "192.0.2.1"},
+		{true, "198.51.100.7", "198.51.100.7"},
+		// clients can send X-Forwarded-For of their own, which the proxy appends to
+		{true, "203.0.113.9, 198.51.100.7", "198.51.100.7"},
+		{true, "203.0.113.9,198.51.100.7 ", "198.51.100.7"},
+	} {
+		req := httptest.NewRequest("GET", "/api/v1/results", nil)
+		req.RemoteAddr = "192.0.2.1:51234"
+		if test.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		l := newLimits(0, 0, rateLimit{}, rateLimit{}, test.trustProxy)
+		if got := l.clientIP(req); got != test.want {
+			t.Errorf("trustProxy %v, X-Forwarded-For %q: %s, want %s", test.trustProxy, test.forwarded, got, test.want)
+		}
+	}
+}
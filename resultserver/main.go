@@ -1,29 +1,71 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"math"
 	"net/http"
-	"net/rpc"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/fivegreenapples/live-o-results/export"
+	"github.com/fivegreenapples/live-o-results/feed"
 	"github.com/fivegreenapples/live-o-results/liveo"
 
+	"github.com/gorilla/websocket"
 	"gopkg.in/igm/sockjs-go.v2/sockjs"
 
 	"sync"
-
-	"io/ioutil"
 )
 
+// FeedSchemaEndpoint serves the JSON Schema of the viewer feed format
+const FeedSchemaEndpoint = "/schema/feed-v1.json"
+
+// ExportPrefix is the URI path under which results are served as CSV, IOF XML and printable HTML
+const ExportPrefix = "/export/"
+
+// viewerUpdate is a change of results, in each of the formats viewers may ask for
+type viewerUpdate struct {
+	delta liveo.ResultDelta
+	feed  feed.Delta
+	// previous and results are the full results before and after the change
+	previous liveo.ResultDataSet
+	results  liveo.ResultDataSet
+	// deltaJSON and feedJSON are delta and feed encoded, once for every viewer, and deltaEvent
+	// and feedEvent the sockjs messages carrying them
+	deltaJSON  json.RawMessage
+	feedJSON   json.RawMessage
+	deltaEvent string
+	feedEvent  string
+	// views shares the work of sending the update between viewers following the same courses
+	views *viewCache
+}
+
 func main() {
 
 	listenInterface := flag.String("interface", "", "HTTP Port")
 	htdocs := flag.String("htdocs", "", "Docroot of Results Web Site")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file. Serves HTTPS when given with -tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	tlsClientCA := flag.String("tls-client-ca", "", "CA bundle used to require client certificates on the RPC endpoint")
+	subscribeTo := flag.String("subscribe", "", "Comma separated filewatcher addresses to pull results from. Filewatchers aren't discovered, so each must be listed")
+	subscribeTLSCA := flag.String("subscribe-tls-ca", "", "CA bundle used to verify filewatchers subscribed to. Enables TLS for subscriptions")
+	relayTo := flag.String("relay", "", "Comma separated result server addresses to relay results to")
+	relayTLSCA := flag.String("relay-tls-ca", "", "CA bundle used to verify result servers relayed to, in place of the system roots. Enables TLS for relays")
+	relayTLSCert := flag.String("relay-tls-cert", "", "Client certificate file, for result servers relayed to requiring one. Enables TLS for relays")
+	relayTLSKey := flag.String("relay-tls-key", "", "Client certificate private key file for relays")
+	dataDir := flag.String("data-dir", "", "Directory in which results are saved so they survive a restart")
+	corsOrigins := flag.String("cors-origins", "*", "Comma separated origins allowed to use the results API from a browser, or * for any")
+	maxSessions := flag.Int("max-sessions", 5000, "Maximum concurrent viewer sessions, or 0 for no limit")
+	maxSessionsPerIP := flag.Int("max-sessions-per-ip", 200, "Maximum concurrent viewer sessions from one address, or 0 for no limit")
+	fullSetRate := flag.Float64("full-set-rate", 1, "Requests for full results allowed per second from one address, in bursts of ten times as many, or 0 for no limit")
+	apiRate := flag.Float64("api-rate", 10, "HTTP API requests allowed per second from one address, in bursts of ten times as many, or 0 for no limit")
+	trustProxy := flag.Bool("trust-proxy", false, "Take client addresses from X-Forwarded-For, as set by a reverse proxy")
 	flag.Parse()
 	if *listenInterface == "" {
 		log.Fatalln("No interface specified (-interface)")
@@ -31,132 +73,234 @@ func main() {
 	if *htdocs == "" {
 		log.Fatalln("No htdocs provided (-htdocs)")
 	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatalln("Both -tls-cert and -tls-key are required to serve TLS")
+	}
+	if *tlsClientCA != "" && *tlsCert == "" {
+		log.Fatalln("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+	if (*relayTLSCert == "") != (*relayTLSKey == "") {
+		log.Fatalln("Both -relay-tls-cert and -relay-tls-key are required to present a client certificate")
+	}
 
 	var currentResultSet struct {
 		sync.RWMutex
 		liveo.ResultDataSet
 	}
-	var resultsWatchers struct {
-		sync.RWMutex
-		w map[string]func(liveo.ResultDelta)
+	viewers := newHub()
+	encodedResults := newResultsCache()
+	limiter := newLimits(*maxSessions, *maxSessionsPerIP,
+		rateLimit{PerSecond: *fullSetRate, Burst: math.Max(1, 10**fullSetRate), MaxWait: 5 * time.Second},
+		rateLimit{PerSecond: *apiRate, Burst: math.Max(1, 10**apiRate)},
+		*trustProxy)
+	var state savedState
+	var saver *stateSaver
+	if *dataDir != "" {
+		if err := os.MkdirAll(*dataDir, 0755); err != nil {
+			log.Fatalln("Couldn't create data directory:", err)
+		}
+		var err error
+		if state, err = loadState(*dataDir); err != nil {
+			log.Println("Ignoring saved state:", err)
+		} else if state.Results.Hash != 0 {
+			log.Println("Restored results", state.Results.Hash, state.Results.Results.Title)
+		}
+		saver = newStateSaver(*dataDir)
+	}
+	currentResultSet.ResultDataSet = state.Results
+	history := &resultHistory{}
+	for _, delta := range state.History {
+		history.add(delta)
 	}
-	resultsWatchers.w = map[string]func(liveo.ResultDelta){}
+	events := newEventStream(state.LastEventSeq)
 
-	rr := newResultsReceiver(func(r liveo.ResultDataSet) {
+	relayAddresses := []string{}
+	for _, addr := range strings.Split(*relayTo, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			relayAddresses = append(relayAddresses, addr)
+		}
+	}
+	var relayTLS *liveo.TLSSettings
+	if *relayTLSCA != "" || *relayTLSCert != "" {
+		relayTLS = &liveo.TLSSettings{
+			Enabled:  true,
+			CAFile:   *relayTLSCA,
+			CertFile: *relayTLSCert,
+			KeyFile:  *relayTLSKey,
+		}
+	}
+	downstream := newRelay(relayAddresses, relayTLS)
+
+	rr := newResultsReceiver(state.Results, func(r liveo.ResultDataSet, replaced bool) {
 		currentResultSet.RLock()
-		delta := currentResultSet.ResultDataSet.DeltaTo(r)
+		previous := currentResultSet.ResultDataSet
+		delta := previous.DeltaTo(r)
+		unchanged := currentResultSet.Hash == r.Hash
+		var resultEvents []feed.Event
+		if !unchanged && currentResultSet.Hash != 0 && !replaced {
+			// neither the first results nor results replaced wholesale, such as by a producer
+			// starting afresh, are news
+			resultEvents = feed.Events(currentResultSet.ResultDataSet, delta, r, time.Now())
+		}
 		currentResultSet.RUnlock()
 
+		// Only relay changes. Besides saving bandwidth this stops results circulating forever
+		// should relays be configured in a loop.
+		if !unchanged {
+			downstream.submitResults(r)
+		}
+
 		currentResultSet.Lock()
 		log.Println("Storing new results")
 		currentResultSet.ResultDataSet = r
+		if !unchanged {
+			history.add(delta)
+		}
 		currentResultSet.Unlock()
 
-		resultsWatchers.RLock()
-		for _, watcher := range resultsWatchers.w {
-			watcher(delta)
+		viewers.broadcast(viewerUpdate{
+			delta:    delta,
+			feed:     feed.FromDelta(delta, r),
+			previous: previous,
+			results:  r,
+		})
+
+		events.publish(resultEvents)
+
+		if saver != nil && !unchanged {
+			saver.save(savedState{
+				Results:      r,
+				History:      history.all(),
+				LastEventSeq: events.latestSeq(),
+			})
 		}
-		resultsWatchers.RUnlock()
 	})
-	rrPublicAPI := ReceiverAPI{rr}
+	http.Handle(liveo.RPCEndpoint, rpcHandler(rr, *tlsClientCA != ""))
 
-	rpcServer := rpc.NewServer()
-	rpcErr := rpcServer.RegisterName("Api", &rrPublicAPI)
-	if rpcErr != nil {
-		log.Panic(rpcErr)
+	var subscribeTLS *liveo.TLSSettings
+	if *subscribeTLSCA != "" {
+		subscribeTLS = &liveo.TLSSettings{Enabled: true, CAFile: *subscribeTLSCA}
 	}
-
-	http.HandleFunc(liveo.RPCEndpoint, func(w http.ResponseWriter, req *http.Request) {
-		if req.Method != "GET" {
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			io.WriteString(w, "405 must CONNECT\n")
-			return
+	for _, addr := range strings.Split(*subscribeTo, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			go pullResults(addr, rr, subscribeTLS)
 		}
+	}
 
-		body, err := ioutil.ReadAll(req.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, "500 "+err.Error()+"\n")
-			return
-		}
-		if string(body) != liveo.SharedSecret {
-			fmt.Println("Unauthorized " + string(body))
-			w.WriteHeader(http.StatusUnauthorized)
-			io.WriteString(w, "401 Unauthorized\n")
-			return
-		}
+	http.Handle(RelayStatusEndpoint, downstream)
+	http.Handle(PushEndpoint, &pushAPI{
+		rr:                rr,
+		requireClientCert: *tlsClientCA != "",
+	})
 
-		conn, _, err := w.(http.Hijacker).Hijack()
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, "500 "+err.Error()+"\n")
-			return
+	allowedOrigins := []string{}
+	for _, origin := range strings.Split(*corsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins = append(allowedOrigins, origin)
 		}
-		conn.SetDeadline(time.Time{})
+	}
+	currentResults := func() liveo.ResultDataSet {
+		currentResultSet.RLock()
+		defer currentResultSet.RUnlock()
+		return currentResultSet.ResultDataSet
+	}
+	http.Handle(RestAPIPrefix, limiter.limitAPI(&restAPI{
+		current:     currentResults,
+		corsOrigins: allowedOrigins,
+	}))
+	// catchUp returns the current results along with the delta to them from those identified by
+	// lastHash, taken together so that the two agree
+	catchUp := func(lastHash string) (liveo.ResultDataSet, liveo.ResultDelta, bool) {
+		currentResultSet.RLock()
+		defer currentResultSet.RUnlock()
+		delta, ok := history.deltaSince(viewerHash(lastHash))
+		return currentResultSet.ResultDataSet, delta, ok
+	}
+	http.Handle(StreamEndpoint, limiter.limitAPI(&resultsStream{
+		catchUp: catchUp,
+		current: currentResults,
+		hub:     viewers,
+		limits:  limiter,
+	}))
+	http.Handle(LimitsStatusEndpoint, limiter)
+	http.Handle(HTMLPagesPrefix, limiter.limitAPI(&htmlPages{current: currentResults}))
+	http.Handle(ExportPrefix, limiter.limitAPI(export.Handler(ExportPrefix, currentResults)))
 
-		io.WriteString(conn, "HTTP/1.0 "+liveo.RPCConnectedStatus+"\r\n\r\n")
-		log.Printf("Handling RPC Connection from %s", req.RemoteAddr)
-		rpcServer.ServeConn(conn)
-	})
+	http.Handle(FeedSchemaEndpoint, limiter.limitAPI(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(feed.Schema())
+	})))
 
 	http.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir(*htdocs))))
 
-	type socketEventMsg struct {
-		Type string
-		Msg  struct {
-			Name string
-			Data interface{}
-		}
+	// Negotiate permessage-deflate with viewers' websockets. Origin checking is left off as it was
+	// with sockjs's default upgrader.
+	socketOptions := sockjs.DefaultOptions
+	socketOptions.WebsocketUpgrader = &websocket.Upgrader{
+		EnableCompression: true,
+		CheckOrigin:       func(*http.Request) bool { return true },
 	}
-	socketsHandler := sockjs.NewHandler("/sockjs", sockjs.DefaultOptions, func(session sockjs.Session) {
+	socketsHandler := sockjs.NewHandler("/sockjs", socketOptions, limiter.limitSessions(func(session sockjs.Session) {
 
 		log.Println("Socket session started", session.ID())
 
-		var sendResults = func(res liveo.ResultDataSet) {
-			ev := socketEventMsg{}
-			ev.Type = "Event"
-			ev.Msg.Name = "NewResults"
-			ev.Msg.Data = res
-			evMsg, _ := json.Marshal(&ev)
-			session.Send(string(evMsg))
-		}
+		// Viewers get the legacy liveo JSON unless they ask for a version of the feed format
+		// with "Format <version>"
+		v := newViewer(func(msg string) {
+			session.Send(msg)
+		}, encodedResults)
+		ip := limiter.clientIP(session.Request())
 
-		// Set up watcher to send deltas for new results
-		var sendDelta = func(delta liveo.ResultDelta) {
-			ev := socketEventMsg{}
-			ev.Type = "Event"
-			ev.Msg.Name = "NewDelta"
-			ev.Msg.Data = delta
-			evMsg, _ := json.Marshal(&ev)
-			session.Send(string(evMsg))
-		}
-		resultsWatchers.Lock()
-		resultsWatchers.w[session.ID()] = sendDelta
-		resultsWatchers.Unlock()
+		// Updates are sent from a goroutine of the session's own so that a slow viewer holds up
+		// no one else
+		sub := viewers.join(session.ID())
+		go func() {
+			for update := range sub.updates {
+				v.update(update)
+				if len(sub.updates) == 0 && sub.lagged() {
+					v.resync(currentResults())
+				}
+			}
+		}()
 
-		// Some handler for incoming messages - this seems pointless
-		var sockRecvErr error
-		var msg string
 		for {
-			if msg, sockRecvErr = session.Recv(); sockRecvErr == nil {
-				if msg == "RequestResults" {
-					currentResultSet.RLock()
-					sendResults(currentResultSet.ResultDataSet)
-					currentResultSet.RUnlock()
+			msg, sockRecvErr := session.Recv()
+			if sockRecvErr != nil {
+				break
+			}
+			command, arg, _ := strings.Cut(msg, " ")
+			switch command {
+			case "RequestResults":
+				if !limiter.waitFullSet(ip) {
+					errMsg, _ := json.Marshal(map[string]string{"Type": "Error", "Msg": "too many requests"})
+					session.Send(string(errMsg))
+					continue
+				}
+				// Viewers send the hash of the results they have, if any, so that they can
+				// catch up with a single delta. It's sent outside the results lock.
+				current, delta, caughtUp := catchUp(arg)
+				v.requestResults(current, delta, caughtUp, arg)
+			case "Format":
+				version, _ := strconv.Atoi(arg)
+				if !v.setFormat(version) {
+					errMsg, _ := json.Marshal(map[string]string{"Type": "Error", "Msg": "unsupported format " + arg})
+					session.Send(string(errMsg))
 				}
-				continue
+			case "Subscribe", "Unsubscribe":
+				v.subscribe(arg, command == "Subscribe", currentResults())
+			case "SubscribeAll", "UnsubscribeAll":
+				v.subscribeAll(command == "SubscribeAll", currentResults())
 			}
-			break
 		}
 
-		resultsWatchers.Lock()
-		delete(resultsWatchers.w, session.ID())
-		resultsWatchers.Unlock()
+		viewers.leave(sub)
 
 		log.Println("Socket session ended", session.ID())
-	})
+	}))
 	http.Handle("/sockjs/", socketsHandler)
+	http.Handle(EventsEndpoint, limiter.limitAPI(events))
+	http.Handle(EventsSocketPrefix+"/", sockjs.NewHandler(EventsSocketPrefix, socketOptions, limiter.limitSessions(events.serveSession)))
 
 	//
 	// Create a server with explicit read and write timeouts
@@ -167,11 +311,51 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	var err error
+	if *tlsCert != "" {
+		srv.TLSConfig, err = serverTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			log.Fatalln("TLS configuration error:", err)
+		}
+		// without a TLSNextProto of its own the server would offer h2 as well
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
 	// Launch server and check for errors
 	fmt.Println("Launching server")
-	err := srv.ListenAndServe()
+	if srv.TLSConfig != nil {
+		// certificates come from TLSConfig.GetCertificate so no files are passed here
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if err != nil {
 		fmt.Println("Couldn't start server: " + err.Error())
 		os.Exit(1)
 	}
 }
+
+// serverTLSConfig returns a TLS config serving the given certificate and key, reloading them when
+// they change on disk. If clientCA is set, client certificates are requested and verified against
+// it; the RPC endpoint then refuses connections that didn't present one.
+func serverTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	reloader, err := liveo.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		// The RPC endpoint hijacks the connection so HTTP/2 must not be negotiated. Servers
+		// must also set an empty TLSNextProto, or they add h2 to these.
+		NextProtos: []string{"http/1.1"},
+	}
+	if clientCA != "" {
+		pool, err := liveo.LoadCertPool(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
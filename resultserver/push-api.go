@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// PushEndpoint is the URI path of the JSON push API
+const PushEndpoint = "/push/v1/results"
+
+// maxPushBodySize bounds the size of a pushed result set or delta
+const maxPushBodySize = 16 << 20
+
+// pushAPI is the JSON over HTTP equivalent of ReceiverAPI, allowing producers other than
+// filewatcher to publish results. It handles:
+//
+//	GET   responds with the hash of the current result set and of each of its courses
+//	PUT   replaces the current results with a ResultDataSet
+//	PATCH applies a ResultDelta to the current results
+//
+// Requests must carry the shared secret as "Authorization: Bearer <secret>" and, when the server
+// requires client certificates for RPC, a verified client certificate. Successful requests
+// respond with the now current hash as {"Hash":n}.
+type pushAPI struct {
+	rr                *resultsReceiver
+	requireClientCert bool
+}
+
+type pushResponse struct {
+	Hash    uint64   `json:",omitempty"`
+	Courses []uint64 `json:",omitempty"`
+	Error   string   `json:",omitempty"`
+}
+
+func (p *pushAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if p.requireClientCert && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+		p.respond(w, http.StatusUnauthorized, pushResponse{Error: "client certificate required"})
+		return
+	}
+	if !authorisedBySecret(req) {
+		log.Println("Unauthorized push API request from", req.RemoteAddr)
+		p.respond(w, http.StatusUnauthorized, pushResponse{Error: "unauthorized"})
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxPushBodySize)
+	switch req.Method {
+	case http.MethodGet:
+		hashes := p.rr.courseHashes()
+		p.respond(w, http.StatusOK, pushResponse{Hash: hashes.Hash, Courses: hashes.Courses})
+	case http.MethodPut:
+		var rs liveo.ResultDataSet
+		if err := json.NewDecoder(req.Body).Decode(&rs); err != nil {
+			p.respond(w, http.StatusBadRequest, pushResponse{Error: "invalid result set: " + err.Error()})
+			return
+		}
+		log.Println("Received results over HTTP.", rs.Hash, rs.Results.Title)
+		p.rr.submitNewResults(rs, false)
+		p.respond(w, http.StatusOK, pushResponse{Hash: p.rr.currentHash()})
+	case http.MethodPatch:
+		var delta liveo.ResultDelta
+		if err := json.NewDecoder(req.Body).Decode(&delta); err != nil {
+			p.respond(w, http.StatusBadRequest, pushResponse{Error: "invalid delta: " + err.Error()})
+			return
+		}
+		log.Println("Received results delta over HTTP.", delta.Old, delta.New)
+		if err := p.rr.submitDelta(delta, false); err != nil {
+			// the producer should fall back to sending the full set
+			log.Println("delta error: ", err)
+			p.respond(w, http.StatusConflict, pushResponse{Error: err.Error()})
+			return
+		}
+		p.respond(w, http.StatusOK, pushResponse{Hash: p.rr.currentHash()})
+	default:
+		w.Header().Set("Allow", "GET, PUT, PATCH")
+		p.respond(w, http.StatusMethodNotAllowed, pushResponse{Error: "method not allowed"})
+	}
+}
+
+func (p *pushAPI) respond(w http.ResponseWriter, status int, resp pushResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// authorisedBySecret reports whether req carries the shared secret as a bearer token
+func authorisedBySecret(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(liveo.SharedSecret)) == 1
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+	"github.com/fivegreenapples/live-o-results/pusher"
+)
+
+// RelayStatusEndpoint is the URI path reporting the status of each downstream relay
+const RelayStatusEndpoint = "/relay/status"
+
+// A relay forwards every result set received by this server to downstream ResultServers, so a
+// single ingest server can feed several edge servers. Downstream servers may relay onwards in
+// turn. Each downstream is fed from its own goroutine so a slow or unreachable server holds up
+// neither the receipt of results nor the other downstreams. A downstream that falls behind is
+// sent only the latest set.
+type relay struct {
+	servers []*pusher.Server
+}
+
+// newRelay returns a relay to the servers at addresses. Without tlsSettings TLS is used only for
+// port 443.
+func newRelay(addresses []string, tlsSettings *liveo.TLSSettings) *relay {
+	r := relay{}
+	for _, addr := range addresses {
+		r.servers = append(r.servers, pusher.NewServer(addr, tlsSettings, nil))
+	}
+	return &r
+}
+
+// submitResults queues rs for relaying, replacing any set not yet relayed
+func (r *relay) submitResults(rs liveo.ResultDataSet) {
+	for _, s := range r.servers {
+		s.Submit(rs)
+	}
+}
+
+// ServeHTTP reports the status of each downstream server, keyed by address
+func (r *relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !authorisedBySecret(req) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	status := map[string]pusher.Status{}
+	for _, s := range r.servers {
+		status[s.Address()] = s.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fivegreenapples/live-o-results/feed"
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// RestAPIPrefix is the URI path under which the read-only JSON API is served
+const RestAPIPrefix = "/api/v1/"
+
+// restAPI serves the current results in the feed format over plain HTTP, for club websites and
+// scripts which would rather not speak sockjs:
+//
+//	GET /api/v1/results             the full results
+//	GET /api/v1/courses             the courses with the number of competitors on each
+//	GET /api/v1/courses/{title}     a single course
+//	GET /api/v1/clubs/{club}        the results, keeping only competitors of a club
+//	GET /api/v1/competitors?q=name  the results, keeping only competitors whose name contains q
+//
+// Filtered results keep competitors' standings on their full course and omit courses with no
+// matching competitors. Responses carry a weak ETag of the results hash so that polling clients
+// may send If-None-Match, and are gzipped for clients accepting it. Cross-origin requests are
+// allowed from corsOrigins, where "*" allows any origin.
+type restAPI struct {
+	current     func() liveo.ResultDataSet
+	corsOrigins []string
+}
+
+// courseList is the response listing courses
+type courseList struct {
+	Version int             `json:"version"`
+	Hash    string          `json:"hash"`
+	Title   string          `json:"title"`
+	Courses []courseSummary `json:"courses"`
+}
+
+type courseSummary struct {
+	ID              string  `json:"id,omitempty"`
+	Title           string  `json:"title"`
+	Info            string  `json:"info"`
+	DistanceKm      float64 `json:"distanceKm,omitempty"`
+	CompetitorCount int     `json:"competitorCount"`
+}
+
+type restError struct {
+	Error string `json:"error"`
+}
+
+func (a *restAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	a.allowOrigin(w, req)
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+		w.Header().Set("Access-Control-Allow-Headers", "If-None-Match")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		a.respond(w, req, http.StatusMethodNotAllowed, restError{"method not allowed"})
+		return
+	}
+
+	// the path is resolved first so that unknown paths are answered 404 whatever the ETag
+	rs := a.current()
+	status, body := a.resolve(req, rs)
+	if status == http.StatusOK {
+		etag := `W/"` + feed.FormatHash(rs.Hash) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	a.respond(w, req, status, body)
+}
+
+// resolve returns the status and body answering req from rs
+func (a *restAPI) resolve(req *http.Request, rs liveo.ResultDataSet) (int, interface{}) {
+	path := strings.TrimPrefix(req.URL.Path, RestAPIPrefix)
+	switch {
+	case path == "results":
+		return http.StatusOK, feed.FromResults(rs)
+	case path == "courses":
+		list := courseList{
+			Version: feed.Version,
+			Hash:    feed.FormatHash(rs.Hash),
+			Title:   rs.Results.Title,
+			Courses: []courseSummary{},
+		}
+		for _, c := range feed.FromResults(rs).Courses {
+			list.Courses = append(list.Courses, courseSummary{
+				ID:              c.ID,
+				Title:           c.Title,
+				Info:            c.Info,
+				DistanceKm:      c.DistanceKm,
+				CompetitorCount: len(c.Competitors),
+			})
+		}
+		return http.StatusOK, list
+	case strings.HasPrefix(path, "courses/"):
+		title := strings.TrimPrefix(path, "courses/")
+		for _, c := range feed.FromResults(rs).Courses {
+			if strings.EqualFold(c.Title, title) {
+				return http.StatusOK, c
+			}
+		}
+		return http.StatusNotFound, restError{"no course " + title}
+	case strings.HasPrefix(path, "clubs/"):
+		club := strings.TrimSpace(strings.TrimPrefix(path, "clubs/"))
+		results := filterCompetitors(rs, func(c feed.Competitor) bool {
+			return strings.EqualFold(strings.TrimSpace(c.Club), club)
+		})
+		if len(results.Courses) == 0 {
+			return http.StatusNotFound, restError{"no club " + club}
+		}
+		return http.StatusOK, results
+	case path == "competitors":
+		q := strings.ToLower(strings.TrimSpace(req.URL.Query().Get("q")))
+		if q == "" {
+			return http.StatusBadRequest, restError{"missing q"}
+		}
+		return http.StatusOK, filterCompetitors(rs, func(c feed.Competitor) bool {
+			return strings.Contains(strings.ToLower(c.Name), q)
+		})
+	}
+	return http.StatusNotFound, restError{"not found"}
+}
+
+// filterCompetitors returns rs in the feed format keeping only competitors for which keep is
+// true, and the courses they're on
+func filterCompetitors(rs liveo.ResultDataSet, keep func(feed.Competitor) bool) feed.Results {
+	results := feed.FromResults(rs)
+	courses := []feed.Course{}
+	for _, c := range results.Courses {
+		competitors := []feed.Competitor{}
+		for _, cp := range c.Competitors {
+			if keep(cp) {
+				competitors = append(competitors, cp)
+			}
+		}
+		if len(competitors) > 0 {
+			c.Competitors = competitors
+			courses = append(courses, c)
+		}
+	}
+	results.Courses = courses
+	return results
+}
+
+// allowOrigin sets the CORS headers for req if its origin is allowed
+func (a *restAPI) allowOrigin(w http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	for _, allowed := range a.corsOrigins {
+		if allowed == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if origin != "" && strings.EqualFold(allowed, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		} else {
+			continue
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
+		return
+	}
+}
+
+// respond writes v as JSON, gzipped if the client accepts it
+func (a *restAPI) respond(w http.ResponseWriter, req *http.Request, status int, v interface{}) {
+	encoded, _ := json.Marshal(v)
+	writeBody(w, req, status, "application/json", append(encoded, '\n'))
+}
+
+// writeBody writes a response, gzipped if the client accepts it
+func writeBody(w http.ResponseWriter, req *http.Request, status int, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(req) {
+		w.WriteHeader(status)
+		if req.Method != http.MethodHead {
+			w.Write(body)
+		}
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(status)
+	if req.Method != http.MethodHead {
+		gz := gzip.NewWriter(w)
+		gz.Write(body)
+		gz.Close()
+	}
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		params = strings.TrimSpace(params)
+		if !strings.HasPrefix(params, "q=") {
+			return true
+		}
+		q, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+		return err == nil && q > 0
+	}
+	return false
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
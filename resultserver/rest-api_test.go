@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/feed"
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+func restTestResults() liveo.ResultDataSet {
+	rs := receiverTestResults(time.Minute, 2*time.Minute)
+	rs.Results.Courses[0].Competitors[0].Club = "Alpha"
+	rs.Hash = liveo.HashResults(rs.Results)
+	return rs
+}
+
+func TestRestAPIStatus(t *testing.T) {
+	rs := restTestResults()
+	api := &restAPI{current: func() liveo.ResultDataSet { return rs }, corsOrigins: []string{"*"}}
+	current := `W/"` + feed.FormatHash(rs.Hash) + `"`
+
+	tests := []struct {
+		path        string
+		ifNoneMatch string
+		want        int
+	}{
+		{"/api/v1/results", "", http.StatusOK},
+		{"/api/v1/results", current, http.StatusNotModified},
+		{"/api/v1/results", `W/"stale"`, http.StatusOK},
+		{"/api/v1/courses/blue", current, http.StatusNotModified},
+		{"/api/v1/courses/Red", current, http.StatusNotFound},
+		{"/api/v1/clubs/alpha", current, http.StatusNotModified},
+		{"/api/v1/clubs/Beta", current, http.StatusNotFound},
+		{"/api/v1/competitors", current, http.StatusBadRequest},
+		{"/api/v1/unknown", current, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("GET %s with If-None-Match %q: status %d, want %d", tt.path, tt.ifNoneMatch, w.Code, tt.want)
+		}
+		if hasETag := w.Header().Get("ETag") != ""; hasETag != (tt.want == http.StatusOK || tt.want == http.StatusNotModified) {
+			t.Errorf("GET %s: ETag %q on status %d", tt.path, w.Header().Get("ETag"), w.Code)
+		}
+	}
+}
@@ -1,135 +1,110 @@
 package main
 
 import (
-	"errors"
 	"fmt"
+	"log"
 
 	"github.com/fivegreenapples/live-o-results/liveo"
-	"github.com/mitchellh/hashstructure"
 )
 
 type resultsReceiver struct {
-	controlCh      chan interface{}
-	doneCh         chan struct{}
-	resultCallback func(liveo.ResultDataSet)
+	controlCh chan interface{}
+	doneCh    chan struct{}
+	// resultCallback is called with each set received, and whether it replaced the results
+	// wholesale rather than being a delta from them
+	resultCallback func(rs liveo.ResultDataSet, replaced bool)
 }
 
+// Producers without CapContentHash identify results by LegacyHashResults, so the events they
+// submit are marked legacy
 type evNewResultSet struct {
 	resultSet liveo.ResultDataSet
+	legacy    bool
 	result    chan error
 }
 type evNewDelta struct {
 	delta  liveo.ResultDelta
+	legacy bool
 	result chan error
 }
+type evGetHash struct {
+	hash chan uint64
+}
+type evGetCourseHashes struct {
+	hashes chan liveo.CourseHashesReply
+}
 type evStop struct{}
 
-func newResultsReceiver(cb func(liveo.ResultDataSet)) *resultsReceiver {
+// newResultsReceiver returns a resultsReceiver holding initial, which is the base for the first
+// delta submitted
+func newResultsReceiver(initial liveo.ResultDataSet, cb func(rs liveo.ResultDataSet, replaced bool)) *resultsReceiver {
 	r := resultsReceiver{
 		controlCh:      make(chan interface{}),
 		doneCh:         make(chan struct{}),
 		resultCallback: cb,
 	}
-	go r.run()
+	go r.run(initial)
 	return &r
 }
 
-func (r *resultsReceiver) run() {
+func (r *resultsReceiver) run(initial liveo.ResultDataSet) {
 
-	var currentResultSet liveo.ResultDataSet
+	currentResultSet := initial
+	// legacyHash is the LegacyHashResults hash of the current results, if they came from a
+	// producer identifying results by it, and is the base its next delta is expected from
+	var legacyHash uint64
 
 RANGELOOP:
 	for ev := range r.controlCh {
 		switch ev := ev.(type) {
+		case evGetHash:
+			ev.hash <- currentResultSet.Hash
+		case evGetCourseHashes:
+			ev.hashes <- liveo.CourseHashesReply{
+				Hash:    currentResultSet.Hash,
+				Courses: liveo.CourseHashes(currentResultSet.Results),
+			}
 		case evNewResultSet:
 			currentResultSet = ev.resultSet
-			r.resultCallback(currentResultSet)
+			// producers other than filewatcher may leave hashing to us, and those predating
+			// content hashes use a different hash
+			currentResultSet.Hash = liveo.HashResults(currentResultSet.Results)
+			legacyHash = 0
+			if ev.legacy {
+				legacyHash = liveo.LegacyHashResults(currentResultSet.Results)
+			} else if ev.resultSet.Hash != 0 && ev.resultSet.Hash != currentResultSet.Hash {
+				log.Printf("Results hash %d didn't match content hash %d", ev.resultSet.Hash, currentResultSet.Hash)
+			}
+			r.resultCallback(currentResultSet, true)
 			ev.result <- nil
 		case evNewDelta:
-			if currentResultSet.Hash != ev.delta.Old {
-				ev.result <- errors.New("delta from unknown base")
-				continue
-			}
-
-			// clone current result set
-			newResultSet := currentResultSet.Clone()
-			// copy over updated details
-			if ev.delta.Title != nil {
-				newResultSet.Results.Title = *ev.delta.Title
-			}
-
-			if ev.delta.Courses != nil {
-				cursorA, cursorB := 0, 0
-				toAdd := len(ev.delta.Courses.Added)
-				newResultSet.Results.Courses = []liveo.Course{}
-				for toAdd > 0 || cursorA < len(currentResultSet.Results.Courses) {
-					if toAdd > 0 {
-						c, found := ev.delta.Courses.Added[cursorB]
-						if found {
-							newResultSet.Results.Courses = append(newResultSet.Results.Courses, c)
-							toAdd--
-							cursorB++
-							continue
-						}
-					}
-
-					if cursorA < len(currentResultSet.Results.Courses) {
-						_, removed := ev.delta.Courses.Removed[cursorA]
-						if !removed {
-							newResultSet.Results.Courses = append(newResultSet.Results.Courses, currentResultSet.Results.Courses[cursorA])
-						}
-					}
-
-					cursorA++
-					cursorB++
+			delta := ev.delta
+			if ev.legacy {
+				if legacyHash == 0 || delta.Old != legacyHash {
+					ev.result <- fmt.Errorf("delta from unknown legacy base %d, current legacy hash is %d", delta.Old, legacyHash)
+					continue
 				}
+				// apply the delta as if from our own hash, then check the legacy hash instead
+				delta.Old, delta.New = currentResultSet.Hash, 0
 			}
-
-			if ev.delta.Competitors != nil {
-
-				for courseIndex, compDelta := range *ev.delta.Competitors {
-					cursorA, cursorB := 0, 0
-					toAdd := len(compDelta.Added)
-					oldSet := newResultSet.Results.Courses[courseIndex].Competitors
-					newSet := []liveo.Competitor{}
-					for toAdd > 0 || cursorA < len(oldSet) {
-						if toAdd > 0 {
-							c, found := compDelta.Added[cursorB]
-							if found {
-								newSet = append(newSet, c)
-								toAdd--
-								cursorB++
-								continue
-							}
-						}
-
-						if cursorA < len(oldSet) {
-							_, removed := compDelta.Removed[cursorA]
-							if !removed {
-								newSet = append(newSet, oldSet[cursorA])
-							}
-						}
-
-						cursorA++
-						cursorB++
-					}
-					newResultSet.Results.Courses[courseIndex].Competitors = newSet
-				}
-			}
-
-			// check resulting hash
-			hash, _ := hashstructure.Hash(newResultSet.Results, nil)
-			if hash != ev.delta.New {
-				ev.result <- fmt.Errorf("patch results didn't match expected hash. Expected %d vs calculated %d", ev.delta.New, hash)
+			newResultSet, err := currentResultSet.Apply(delta)
+			if err != nil {
+				ev.result <- err
 				continue
 			}
-
-			// store hash
-			newResultSet.Hash = hash
-			// copy back
+			if ev.legacy {
+				newLegacyHash := liveo.LegacyHashResults(newResultSet.Results)
+				if ev.delta.New != 0 && newLegacyHash != ev.delta.New {
+					ev.result <- fmt.Errorf("patched results didn't match expected legacy hash. Expected %d vs calculated %d", ev.delta.New, newLegacyHash)
+					continue
+				}
+				legacyHash = newLegacyHash
+			} else {
+				legacyHash = 0
+			}
 			currentResultSet = newResultSet
 
-			r.resultCallback(currentResultSet)
+			r.resultCallback(currentResultSet, false)
 			ev.result <- nil
 		case evStop:
 			break RANGELOOP
@@ -144,18 +119,34 @@ func (r *resultsReceiver) wait() {
 func (r *resultsReceiver) stop() {
 	r.controlCh <- evStop{}
 }
-func (r *resultsReceiver) submitNewResults(set liveo.ResultDataSet) {
+func (r *resultsReceiver) currentHash() uint64 {
+	hashCh := make(chan uint64)
+	r.controlCh <- evGetHash{
+		hash: hashCh,
+	}
+	return <-hashCh
+}
+func (r *resultsReceiver) courseHashes() liveo.CourseHashesReply {
+	hashesCh := make(chan liveo.CourseHashesReply)
+	r.controlCh <- evGetCourseHashes{
+		hashes: hashesCh,
+	}
+	return <-hashesCh
+}
+func (r *resultsReceiver) submitNewResults(set liveo.ResultDataSet, legacy bool) {
 	resultCh := make(chan error)
 	r.controlCh <- evNewResultSet{
 		resultSet: set,
+		legacy:    legacy,
 		result:    resultCh,
 	}
 	<-resultCh
 }
-func (r *resultsReceiver) submitDelta(delta liveo.ResultDelta) error {
+func (r *resultsReceiver) submitDelta(delta liveo.ResultDelta, legacy bool) error {
 	resultCh := make(chan error)
 	r.controlCh <- evNewDelta{
 		delta:  delta,
+		legacy: legacy,
 		result: resultCh,
 	}
 	return <-resultCh
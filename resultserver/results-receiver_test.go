@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+func receiverTestResults(times ...time.Duration) liveo.ResultDataSet {
+	r := liveo.Results{Title: "Event", Courses: []liveo.Course{{Title: "Blue"}}}
+	for _, t := range times {
+		r.Courses[0].Competitors = append(r.Courses[0].Competitors, liveo.Competitor{Name: t.String(), Time: t, Valid: true})
+	}
+	return liveo.ResultDataSet{Results: r, Hash: liveo.HashResults(r)}
+}
+
+// legacyDelta returns the delta from A to B as sent by a producer without CapContentHash
+func legacyDelta(A, B liveo.ResultDataSet) liveo.ResultDelta {
+	delta := A.DeltaTo(B)
+	delta.Old = liveo.LegacyHashResults(A.Results)
+	delta.New = liveo.LegacyHashResults(B.Results)
+	return delta
+}
+
+func TestReceiverAcceptsLegacyDeltas(t *testing.T) {
+	rr := newResultsReceiver(liveo.ResultDataSet{}, func(liveo.ResultDataSet, bool) {})
+	defer rr.stop()
+
+	A := receiverTestResults(time.Minute)
+	B := receiverTestResults(time.Minute, 2*time.Minute)
+	C := receiverTestResults(time.Minute, 2*time.Minute, 3*time.Minute)
+	D := receiverTestResults(time.Minute, 3*time.Minute)
+
+	legacyA := A
+	legacyA.Hash = liveo.LegacyHashResults(A.Results)
+	rr.submitNewResults(legacyA, true)
+	if got := rr.currentHash(); got != A.Hash {
+		t.Fatalf("current hash %d after a legacy set, want content hash %d", got, A.Hash)
+	}
+
+	for _, step := range []struct{ from, to liveo.ResultDataSet }{{A, B}, {B, C}} {
+		if err := rr.submitDelta(legacyDelta(step.from, step.to), true); err != nil {
+			t.Fatal("legacy delta rejected:", err)
+		}
+		if got := rr.currentHash(); got != step.to.Hash {
+			t.Fatalf("current hash %d after a legacy delta, want %d", got, step.to.Hash)
+		}
+	}
+
+	corrupt := legacyDelta(C, D)
+	corrupt.New++
+	if err := rr.submitDelta(corrupt, true); err == nil {
+		t.Error("legacy delta not giving its expected hash was accepted")
+	}
+	if err := rr.submitDelta(legacyDelta(C, D), false); err == nil {
+		t.Error("delta from a legacy hash was accepted from a content hash producer")
+	}
+
+	// once another producer has changed the results the legacy producer's base is gone
+	if err := rr.submitDelta(C.DeltaTo(D), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := rr.submitDelta(legacyDelta(C, D), true); err == nil {
+		t.Error("legacy delta from replaced results was accepted")
+	}
+}
@@ -190,77 +190,143 @@ App.service("socket", [
 
 App.service("results", [function() {
 
+	// Mirrors liveo applyListDelta: drop the removed items, then place the added items at their
+	// indices with the remaining old items filling the gaps in order.
+	function applyListDelta(oldList, removed, added) {
+		oldList = oldList || []
+		var newLength = oldList.length - Object.keys(removed).length + Object.keys(added).length
+		var newList = []
+		var cursorA = 0
+		while (newList.length < newLength) {
+			if (added.hasOwnProperty(newList.length)) {
+				newList.push(added[newList.length])
+				continue
+			}
+			while (removed.hasOwnProperty(cursorA)) {
+				cursorA++
+			}
+			if (cursorA >= oldList.length) {
+				return false
+			}
+			newList.push(oldList[cursorA])
+			cursorA++
+		}
+		return newList
+	}
+
+	function indexSet(indices) {
+		var set = {}
+		angular.forEach(indices, function(i) {
+			set[i] = true
+		})
+		return set
+	}
+
+	var changeableFields = ["name", "ageClass", "club", "timeMs", "status"]
+	var standingFields = ["position", "behindMs", "percentBehind", "paceMsPerKm"]
+
 	var service = {
-		addDelta: function(hash, results, delta) {
+		// Applies a feed Delta (see /schema/feed-v1.json) to feed Results, returning the new
+		// results or false if the delta doesn't apply.
+		// Competitors placed by the delta are marked with a highlight of "new" or "corrected".
+		addDelta: function(results, delta) {
 
-			if (hash != delta.Old) {
-				console.log("Delta.Old didn't match current result hash.", delta.Old, hash)
+			if (results.hash !== delta.old) {
+				console.log("Delta.old didn't match current result hash.", delta.old, results.hash)
 				return false
 			}
 
 			// clone current result set
-			var newResultSet = {
-				Hash:    delta.New,
-				Results: angular.copy(results)
-			}
+			var newResults = angular.copy(results)
+			newResults.hash = delta.new
+			angular.forEach(newResults.courses, function(course) {
+				angular.forEach(course.competitors, function(competitor) {
+					delete competitor.highlight
+				})
+			})
 
 			// copy over updated details
-			if (delta.hasOwnProperty("Title") && delta.Title !== null) {
-				newResultSet.Results.Title = delta.Title
+			if (delta.hasOwnProperty("title")) {
+				newResults.title = delta.title
 			}
 
-			if (delta.hasOwnProperty("Courses") && delta.Courses !== null) {
-				var cursorA = 0, cursorB = 0
-				var toAdd = Object.keys(delta.Courses.Added).length
-				newResultSet.Results.Courses = []
-				while (toAdd > 0 || (results.Courses && cursorA < results.Courses.length)) {
-					if (toAdd > 0 && delta.Courses.Added[cursorB]) {
-						newResultSet.Results.Courses.push(delta.Courses.Added[cursorB])
-						toAdd--
-						cursorB++
-						continue
+			if (delta.courses) {
+				var oldCourses = newResults.courses || []
+				var removedCourses = indexSet(delta.courses.removed)
+				var placedCourses = {}
+				var coursesOk = true
+				angular.forEach(delta.courses.added, function(placed) {
+					placedCourses[placed.index] = placed.course
+				})
+				angular.forEach(delta.courses.modified, function(change) {
+					var course = oldCourses[change.from]
+					if (!course || !removedCourses[change.from]) {
+						coursesOk = false
+						return
 					}
-
-					if (results.Courses && cursorA < results.Courses.length) {
-						if (!delta.Courses.Removed.hasOwnProperty(cursorA)) {
-							newResultSet.Results.Courses.push(results.Courses[cursorA])
-						}
+					if (change.hasOwnProperty("title") && change.title != course.title) {
+						course.previousTitle = course.title
+						course.title = change.title
 					}
-
-					cursorA++
-					cursorB++
+					if (change.hasOwnProperty("info")) course.info = change.info
+					placedCourses[change.index] = course
+				})
+				var courses = applyListDelta(oldCourses, removedCourses, placedCourses)
+				if (!coursesOk || !courses) {
+					console.log("Bad courses delta.", delta.courses)
+					return false
 				}
+				newResults.courses = courses
 			}
 
-			if (delta.hasOwnProperty("Competitors") && delta.Competitors !== null) {
-				
-				angular.forEach(delta.Competitors, function(compDelta, courseIndex) {
-					var cursorA = 0, cursorB = 0
-					var toAdd = Object.keys(compDelta.Added).length
-					var oldSet = newResultSet.Results.Courses[courseIndex].Competitors
-					var newSet = []
-					while (toAdd > 0 || cursorA < oldSet.length) {
-						if (toAdd > 0 && compDelta.Added[cursorB]) {
-							newSet.push(compDelta.Added[cursorB])
-							toAdd--
-							cursorB++
-							continue
-						}
-
-						if (cursorA < oldSet.length) {
-							if (!compDelta.Removed.hasOwnProperty(cursorA)) {
-								newSet.push(oldSet[cursorA])
-							}
-						}
-
-						cursorA++
-						cursorB++
+			var ok = true
+			angular.forEach(delta.competitors, function(compDelta) {
+				var course = newResults.courses[compDelta.course]
+				if (!course) {
+					ok = false
+					return
+				}
+				var oldSet = course.competitors || []
+				var placed = {}
+				angular.forEach(compDelta.added, function(p) {
+					p.competitor.highlight = "new"
+					placed[p.index] = p.competitor
+				})
+				angular.forEach(compDelta.changed, function(change) {
+					var competitor = angular.copy(oldSet[change.from])
+					if (!competitor) {
+						ok = false
+						return
 					}
-					newResultSet.Results.Courses[courseIndex].Competitors = newSet
+					changeableFields.forEach(function(f) {
+						if (change.hasOwnProperty(f)) competitor[f] = change[f]
+					})
+					competitor.id = change.id
+					competitor.highlight = "corrected"
+					placed[change.index] = competitor
 				})
+				var competitors = applyListDelta(oldSet, indexSet(compDelta.removed), placed)
+				if (!competitors || competitors.length != compDelta.standings.length) {
+					ok = false
+					return
+				}
+				competitors.forEach(function(competitor, i) {
+					standingFields.forEach(function(f) {
+						if (compDelta.standings[i].hasOwnProperty(f)) {
+							competitor[f] = compDelta.standings[i][f]
+						} else {
+							delete competitor[f]
+						}
+					})
+				})
+				course.competitors = competitors
+			})
+			if (!ok) {
+				console.log("Bad competitors delta.", delta.competitors)
+				return false
 			}
-							
-			return newResultSet
+
+			return newResults
 
 		}
 	}
@@ -274,21 +340,45 @@ App.controller("mainCtrl", [
 	"socket",
 	"results",
     function($scope, $http, $timeout, Socket, Results) {
+		// The version of the feed format this page understands
+		var feedVersion = 1
+
 		$scope.socketStatus = {
 			showError: false,
 			connected: false
 		}
-		$scope.resultsHash = 0
 		$scope.results = {
-			Title: "Event Title, Event Date"
+			hash: "",
+			title: "Event Title, Event Date"
 		}
 
 		$scope.courseVisibility = {}
 		loadCourseVisibility()
 
+		// Requesting results with our hash lets the server send a single delta to catch up. Should
+		// that fail we fall back to requesting the full results.
+		var catchingUp = false
+		function requestResults() {
+			if ($scope.results.hash && !catchingUp) {
+				catchingUp = true
+				Socket.sendRawMessage("RequestResults "+$scope.results.hash)
+				return
+			}
+			catchingUp = false
+			Socket.sendRawMessage("RequestResults")
+		}
+
 		Socket.addListener("open", function() {
 			$scope.socketStatus.connected = true
-			Socket.sendRawMessage("RequestResults")
+			catchingUp = false
+			Socket.sendRawMessage("Format "+feedVersion)
+			// the server sends competitors only for courses we show
+			for (var title in $scope.courseVisibility) {
+				if (!$scope.courseVisibility[title]) {
+					Socket.sendRawMessage("Unsubscribe "+title)
+				}
+			}
+			requestResults()
 		}, $scope)
 		Socket.addListener("close", function() {
 			$scope.socketStatus.connected = false
@@ -296,54 +386,78 @@ App.controller("mainCtrl", [
 		$timeout(function() {
 			$scope.socketStatus.showError = true
 		}, 500)
-		Socket.addEventListener("NewResults", function(resultSet) {
-			console.log("NewResults", resultSet)
-			processResultSet(angular.copy(resultSet))
+		Socket.addEventListener("Results", function(results) {
+			console.log("Results", results)
+			catchingUp = false
+			processResults(angular.copy(results))
 		}, $scope)
-		Socket.addEventListener("NewDelta", function(resultDelta) {
-			console.log("NewDelta", resultDelta)
-			var newResultSet = Results.addDelta($scope.resultsHash, $scope.results, resultDelta)
-			if (!newResultSet) {
-				// need to request a full submission
+		Socket.addEventListener("Delta", function(delta) {
+			console.log("Delta", delta)
+			var newResults = Results.addDelta($scope.results, delta)
+			if (!newResults) {
 				console.log("Requesting new results")
-				Socket.sendRawMessage("RequestResults")
+				requestResults()
 				return
 			}
-			console.log("Calculated Results", newResultSet)
-			processResultSet(newResultSet)
+			catchingUp = false
+			console.log("Calculated Results", newResults)
+			processResults(newResults)
 		}, $scope)
 		Socket.connect()
 
 
-		function processResultSet(resultSet) {
-			if (!resultSet || !resultSet.Results || !resultSet.Results.Title) return
+		function formatTime(ms) {
+			var timeTotalSeconds = Math.floor(ms / 1000)
+			var timeMins = Math.floor(timeTotalSeconds / 60)
+			var timeSeconds = timeTotalSeconds % 60
+			if (timeSeconds <= 9) {
+				timeSeconds = "0"+timeSeconds
+			}
+			return timeMins+":"+timeSeconds
+		}
+
+		function processResults(results) {
+			if (!results || results.version != feedVersion || !results.title) return
 
-			if (resultSet.Results.Courses) {
-				resultSet.Results.Courses.forEach(function(course, i) {
-					if (!(course.Title in $scope.courseVisibility)) {
-						$scope.courseVisibility[course.Title] = true
+			if (results.courses) {
+				results.courses.forEach(function(course, i) {
+					if (!(course.title in $scope.courseVisibility)) {
+						// a renamed course stays as visible as it was
+						var previous = $scope.courseVisibility[course.previousTitle]
+						$scope.courseVisibility[course.title] = previous === undefined ? true : previous
+						if (previous === false) {
+							Socket.sendRawMessage("Unsubscribe "+course.title)
+						}
 					}
-					if (!course.Competitors) return 
-					course.Competitors.forEach(function(competitor) {
-						// Time arrives in nanoseconds!
-						var timeTotalSeconds = Math.floor(competitor.Time / 1000000000)
-						var timeMins = Math.floor(timeTotalSeconds / 60)
-						var timeSeconds = timeTotalSeconds % 60
-						if (timeSeconds <= 9) {
-							timeSeconds = "0"+timeSeconds
+					delete course.previousTitle
+					if (!course.competitors) return 
+					course.competitors.forEach(function(competitor) {
+						competitor.timeFormatted = formatTime(competitor.timeMs)
+						competitor.behindFormatted = ""
+						if (competitor.behindMs) {
+							competitor.behindFormatted = "+"+formatTime(competitor.behindMs)+" ("+competitor.percentBehind+"%)"
 						}
-						competitor.TimeFormatted = timeMins+":"+timeSeconds
 					})
 				})
 			}
-			$scope.resultsHash = resultSet.Hash
-			$scope.results     = resultSet.Results
+			$scope.results = results
 			$scope.storeCourseVisibility()
 		}
 
+		$scope.toggleCourse = function(title) {
+			$scope.courseVisibility[title] = !$scope.courseVisibility[title]
+			Socket.sendRawMessage(($scope.courseVisibility[title] ? "Subscribe " : "Unsubscribe ")+title)
+			$scope.storeCourseVisibility()
+		}
 		$scope.toggleAll = function(val) {
 			for (k in $scope.courseVisibility) {
 				$scope.courseVisibility[k] = val
+				if (!val) {
+					Socket.sendRawMessage("Unsubscribe "+k)
+				}
+			}
+			if (val) {
+				Socket.sendRawMessage("SubscribeAll")
 			}
 			$scope.storeCourseVisibility()
 		}
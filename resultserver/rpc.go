@@ -1,25 +1,37 @@
 package main
 
 import (
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"time"
 
 	"github.com/fivegreenapples/live-o-results/liveo"
 )
 
+// ReceiverAPI is served to each RPC connection, with the protocol agreed for it
 type ReceiverAPI struct {
-	rr *resultsReceiver
+	rr       *resultsReceiver
+	protocol liveo.Handshake
+}
+
+// legacy reports whether the peer identifies results by LegacyHashResults
+func (a *ReceiverAPI) legacy() bool {
+	return !a.protocol.Has(liveo.CapContentHash)
 }
 
 func (a *ReceiverAPI) SubmitLatestResults(r *liveo.ResultDataSet, res *bool) error {
 	log.Println("Received results.", r.Hash, r.Results.Title)
-	a.rr.submitNewResults(*r)
+	a.rr.submitNewResults(*r, a.legacy())
 	*res = true
 	return nil
 }
 
 func (a *ReceiverAPI) SubmitDelta(r *liveo.ResultDelta, res *bool) error {
 	log.Println("Received results delta.", r.Old, r.New)
-	err := a.rr.submitDelta(*r)
+	err := a.rr.submitDelta(*r, a.legacy())
 	if err != nil {
 		log.Println("delta error: ", err)
 		*res = false
@@ -28,3 +40,45 @@ func (a *ReceiverAPI) SubmitDelta(r *liveo.ResultDelta, res *bool) error {
 	*res = true
 	return nil
 }
+
+func (a *ReceiverAPI) Ping(args *liveo.PingArgs, reply *liveo.PingReply) error {
+	reply.ServerTime = time.Now()
+	reply.Hash = a.rr.currentHash()
+	return nil
+}
+
+func (a *ReceiverAPI) CourseHashes(args *liveo.CourseHashesArgs, reply *liveo.CourseHashesReply) error {
+	*reply = a.rr.courseHashes()
+	return nil
+}
+
+// rpcHandler accepts RPC connections from filewatchers and relays, serving them the receiver's
+// API. If requireClientCert is set, connections must have presented a verified client certificate.
+func rpcHandler(rr *resultsReceiver, requireClientCert bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if requireClientCert && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+			log.Println("Rejecting RPC connection without client certificate from", req.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, "401 Client Certificate Required\n")
+			return
+		}
+
+		conn, protocol, ok := liveo.AcceptRPC(w, req)
+		if !ok {
+			return
+		}
+		log.Printf("Handling RPC Connection from %s using protocol v%d %v", req.RemoteAddr, protocol.Version, protocol.Capabilities)
+		serveRPC(conn, protocol, rr)
+	})
+}
+
+// serveRPC serves the receiver's API over conn, to a peer which agreed protocol
+func serveRPC(conn net.Conn, protocol liveo.Handshake, rr *resultsReceiver) {
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("Api", &ReceiverAPI{rr: rr, protocol: protocol}); err != nil {
+		log.Println("Couldn't serve RPC:", err)
+		conn.Close()
+		return
+	}
+	rpcServer.ServeConn(conn)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"testing"
+
+	"github.com/fivegreenapples/live-o-results/internal/testcerts"
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+func TestRPCRequiresClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := testcerts.NewCA(t, dir)
+	serverCert, serverKey := ca.Issue(t, dir, "server")
+	clientCert, clientKey := ca.Issue(t, dir, "client")
+
+	rr := newResultsReceiver(liveo.ResultDataSet{}, func(liveo.ResultDataSet, bool) {})
+	defer rr.stop()
+	config, err := serverTLSConfig(serverCert, serverKey, ca.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: rpcHandler(rr, true)}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	settings := liveo.TLSSettings{Enabled: true, CAFile: ca.File, ServerName: "localhost"}
+	if conn, _, err := liveo.DialRPC(ln.Addr().String(), liveo.RPCEndpoint, &settings); err == nil {
+		conn.Close()
+		t.Fatal("connection without a client certificate was accepted")
+	} else if !strings.Contains(err.Error(), "401") {
+		t.Fatalf("connection without a client certificate failed with %v, want 401", err)
+	}
+
+	settings.CertFile, settings.KeyFile = clientCert, clientKey
+	conn, _, err := liveo.DialRPC(ln.Addr().String(), liveo.RPCEndpoint, &settings)
+	if err != nil {
+		t.Fatal("connection with a client certificate failed:", err)
+	}
+	client := rpc.NewClient(conn)
+	defer client.Close()
+	var reply liveo.PingReply
+	if err := client.Call("Api.Ping", &liveo.PingArgs{}, &reply); err != nil {
+		t.Fatal("ping over an authenticated connection failed:", err)
+	}
+}
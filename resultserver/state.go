@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// stateFile is the file in the data directory holding the saved state
+const stateFile = "state.json"
+
+// saveInterval is the least time between saves of the state. A crash loses at most the changes
+// made in this time.
+const saveInterval = 5 * time.Second
+
+// savedState is what the resultserver keeps in its data directory so that a restart carries on
+// where it left off. The restored results are the base for the next delta pushed to us.
+type savedState struct {
+	Results      liveo.ResultDataSet
+	History      []liveo.ResultDelta
+	LastEventSeq int64
+}
+
+// loadState reads the state saved in dir, which is empty if none has been saved
+func loadState(dir string) (savedState, error) {
+	var state savedState
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return savedState{}, fmt.Errorf("%s: %s", stateFile, err)
+	}
+	if hash := liveo.HashResults(state.Results.Results); state.Results.Hash != hash {
+		return savedState{}, fmt.Errorf("%s: results hash %d doesn't match content hash %d", stateFile, state.Results.Hash, hash)
+	}
+	return state, nil
+}
+
+// saveState writes state to dir, replacing any previous state atomically
+func saveState(dir string, state savedState) error {
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, stateFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, stateFile)); err != nil {
+		return err
+	}
+
+	// make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// stateSaver saves state to a data directory from a goroutine of its own, so that receiving
+// results doesn't wait on the disk. Saves are coalesced: only the latest state is written, and
+// at most once every saveInterval.
+type stateSaver struct {
+	dir     string
+	pending chan savedState
+}
+
+func newStateSaver(dir string) *stateSaver {
+	s := &stateSaver{
+		dir:     dir,
+		pending: make(chan savedState, 1),
+	}
+	go s.run()
+	return s
+}
+
+// save queues state to be saved, replacing any state not yet saved
+func (s *stateSaver) save(state savedState) {
+	for {
+		select {
+		case s.pending <- state:
+			return
+		default:
+			// drop the stale state
+			select {
+			case <-s.pending:
+			default:
+			}
+		}
+	}
+}
+
+func (s *stateSaver) run() {
+	for state := range s.pending {
+		if err := saveState(s.dir, state); err != nil {
+			log.Println("Failed to save results:", err)
+		}
+		time.Sleep(saveInterval)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateSaverCoalesces(t *testing.T) {
+	dir := t.TempDir()
+	saver := newStateSaver(dir)
+	for seq := int64(1); seq <= 100; seq++ {
+		saver.save(savedState{Results: receiverTestResults(time.Duration(seq) * time.Second), LastEventSeq: seq})
+	}
+
+	deadline := time.Now().Add(2*saveInterval + time.Second)
+	for time.Now().Before(deadline) {
+		state, err := loadState(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.LastEventSeq == 100 {
+			if want := receiverTestResults(100 * time.Second); state.Results.Hash != want.Hash {
+				t.Fatalf("saved results hash %d, want %d", state.Results.Hash, want.Hash)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("latest state wasn't saved")
+}
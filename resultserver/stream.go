@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/feed"
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// StreamEndpoint serves results as Server-Sent Events
+const StreamEndpoint = "/stream/v1/results"
+
+// streamKeepAlive is how often a comment is sent on an otherwise idle stream so that proxies
+// don't close it
+const streamKeepAlive = 20 * time.Second
+
+// resultsStream follows results over text/event-stream, sending the events sockjs viewers get:
+// NewResults and NewDelta, or Results and Delta in the feed format given ?format=1. Each event's
+// id is the hash of the full results it brings the client to, so a reconnecting client sending
+// Last-Event-ID receives a delta catching up from there, or nothing if it's up to date.
+//
+// Given ?course=<title or id>, possibly repeated, only those courses are sent. Their results and
+// deltas are hashed as a set of their own, though event ids remain hashes of the full results.
+type resultsStream struct {
+	// catchUp returns the current results, and a delta to them from the results with the given
+	// hash if these are recent enough
+	catchUp func(lastHash string) (liveo.ResultDataSet, liveo.ResultDelta, bool)
+	current func() liveo.ResultDataSet
+	hub     *hub
+	limits  *limits
+
+	streams int64
+}
+
+func (s *resultsStream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	useFeed := false
+	if format := req.URL.Query().Get("format"); format != "" {
+		if format != fmt.Sprint(feed.Version) {
+			http.Error(w, "unsupported format "+format, http.StatusBadRequest)
+			return
+		}
+		useFeed = true
+	}
+	scope := courseScope(req.URL.Query()["course"])
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// the server's write timeout would otherwise end the stream, so each write gets its own
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	ip := s.limits.clientIP(req)
+	if !s.limits.openSession(ip) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "too many sessions", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.limits.closeSession(ip)
+
+	id := fmt.Sprint("stream-", atomic.AddInt64(&s.streams, 1))
+	log.Println("Results stream started", id, req.RemoteAddr)
+	defer log.Println("Results stream ended", id)
+
+	// Should the client fall behind it's sent the full results once it has caught up
+	sub := s.hub.join(id)
+	defer s.hub.leave(sub)
+
+	send := func(event string, hash uint64, encoded json.RawMessage) error {
+		rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", event, feed.FormatHash(hash), encoded); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	sendResults := func(rs liveo.ResultDataSet) error {
+		hash := rs.Hash
+		if scope != nil {
+			rs = rs.WithCourses(scope)
+		}
+		if useFeed {
+			encoded, _ := json.Marshal(feed.FromResults(rs))
+			return send("Results", hash, encoded)
+		}
+		encoded, _ := json.Marshal(rs)
+		return send("NewResults", hash, encoded)
+	}
+	sendDelta := func(before, after liveo.ResultDataSet, delta liveo.ResultDelta) error {
+		hash := after.Hash
+		if scope != nil {
+			before, after = before.WithCourses(scope), after.WithCourses(scope)
+			if before.Hash == after.Hash {
+				return nil
+			}
+			delta = before.DeltaTo(after)
+		}
+		if useFeed {
+			encoded, _ := json.Marshal(feed.FromDelta(delta, after))
+			return send("Delta", hash, encoded)
+		}
+		encoded, _ := json.Marshal(delta)
+		return send("NewDelta", hash, encoded)
+	}
+
+	// Start the client off, registered as a watcher first so no update is missed. Updates
+	// the client already has are skipped below.
+	lastEventID := req.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = req.URL.Query().Get("lastEventId")
+	}
+	current, catchUp, caughtUp := s.catchUp(lastEventID)
+	_, err := fmt.Fprint(w, "retry: 5000\n\n")
+	switch {
+	case err != nil:
+	case caughtUp && catchUp.Old == catchUp.New:
+		// the client is up to date
+		flusher.Flush()
+	case caughtUp && scope == nil:
+		err = sendDelta(liveo.ResultDataSet{}, current, catchUp)
+	default:
+		err = sendResults(current)
+	}
+	sentHash := current.Hash
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for err == nil {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err = fmt.Fprint(w, ": keep-alive\n\n"); err == nil {
+				flusher.Flush()
+			}
+		case update := <-sub.updates:
+			switch {
+			case update.results.Hash == sentHash:
+				// already sent
+			case update.previous.Hash == sentHash && scope == nil && useFeed:
+				err = send("Delta", update.results.Hash, update.feedJSON)
+			case update.previous.Hash == sentHash && scope == nil:
+				err = send("NewDelta", update.results.Hash, update.deltaJSON)
+			case update.previous.Hash == sentHash:
+				err = sendDelta(update.previous, update.results, update.delta)
+			default:
+				err = sendResults(update.results)
+			}
+			sentHash = update.results.Hash
+			if err == nil && len(sub.updates) == 0 && sub.lagged() {
+				current = s.current()
+				if current.Hash != sentHash {
+					err = sendResults(current)
+					sentHash = current.Hash
+				}
+			}
+		}
+	}
+	log.Println("Results stream write failed", id, err)
+}
+
+// courseScope returns a func keeping the courses whose title or id is one of names, ignoring
+// case, or nil if there are no names
+func courseScope(names []string) func(liveo.Course) bool {
+	if len(names) == 0 {
+		return nil
+	}
+	return func(c liveo.Course) bool {
+		for _, name := range names {
+			if strings.EqualFold(name, c.Title) || c.ID != "" && strings.EqualFold(name, c.ID) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/feed"
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// streamTestResults returns results with blue finishers on Blue and brown finishers on Brown
+func streamTestResults(blue, brown int) liveo.ResultDataSet {
+	r := liveo.Results{Title: "Event", Courses: []liveo.Course{{Title: "Blue"}, {Title: "Brown"}}}
+	for i := 0; i < blue; i++ {
+		r.Courses[0].Competitors = append(r.Courses[0].Competitors, liveo.Competitor{Name: "Blue " + string(rune('A'+i)), Time: time.Duration(30+i) * time.Minute, Valid: true})
+	}
+	for i := 0; i < brown; i++ {
+		r.Courses[1].Competitors = append(r.Courses[1].Competitors, liveo.Competitor{Name: "Brown " + string(rune('A'+i)), Time: time.Duration(50+i) * time.Minute, Valid: true})
+	}
+	return liveo.ResultDataSet{Results: r, Hash: liveo.HashResults(r)}
+}
+
+type streamEvent struct {
+	name, id string
+	data     json.RawMessage
+}
+
+// testStream serves a results stream whose history runs through sets, the last being current
+type testStream struct {
+	*httptest.Server
+	hub *hub
+
+	mu      sync.Mutex
+	current liveo.ResultDataSet
+}
+
+func newTestStream(t *testing.T, sets ...liveo.ResultDataSet) *testStream {
+	s := &testStream{hub: newHub(), current: sets[len(sets)-1]}
+	history := historyOf(sets...)
+	current := func() liveo.ResultDataSet {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.current
+	}
+	s.Server = httptest.NewServer(&resultsStream{
+		catchUp: func(lastHash string) (liveo.ResultDataSet, liveo.ResultDelta, bool) {
+			delta, ok := history.deltaSince(viewerHash(lastHash))
+			return current(), delta, ok
+		},
+		current: current,
+		hub:     s.hub,
+		limits:  newLimits(0, 0, rateLimit{}, rateLimit{}, false),
+	})
+	t.Cleanup(s.Close)
+	return s
+}
+
+// change makes results current, queueing the change for streams as main does
+func (s *testStream) change(results liveo.ResultDataSet) {
+	s.mu.Lock()
+	previous := s.current
+	s.current = results
+	s.mu.Unlock()
+	s.hub.broadcast(hubUpdate(previous, results))
+}
+
+// open starts a stream, returning its events once the stream is following results
+func (s *testStream) open(t *testing.T, query, lastEventID string) <-chan streamEvent {
+	req, err := http.NewRequest(http.MethodGet, s.URL+StreamEndpoint+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream status %d", resp.StatusCode)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	lines.Buffer(nil, 1<<20)
+	if !lines.Scan() || lines.Text() != "retry: 5000" {
+		t.Fatalf("stream began %q", lines.Text())
+	}
+	events := make(chan streamEvent, 16)
+	go func() {
+		defer close(events)
+		var e streamEvent
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case line == "" && e.name != "":
+				events <- e
+				e = streamEvent{}
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = json.RawMessage(strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}()
+	return events
+}
+
+// nextStreamEvent returns the next event of a stream, which must be name bringing the client to results
+func nextStreamEvent(t *testing.T, events <-chan streamEvent, name string, results liveo.ResultDataSet) streamEvent {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		if e.name != name || e.id != feed.FormatHash(results.Hash) {
+			t.Fatalf("event %s with id %s, want %s with id %d", e.name, e.id, name, results.Hash)
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s event", name)
+	}
+	return streamEvent{}
+}
+
+func TestStreamWithoutLastEventID(t *testing.T) {
+	A, B := streamTestResults(1, 1), streamTestResults(2, 1)
+	s := newTestStream(t, A)
+	events := s.open(t, "", "")
+
+	e := nextStreamEvent(t, events, "NewResults", A)
+	var got liveo.ResultDataSet
+	if err := json.Unmarshal(e.data, &got); err != nil || got.Hash != A.Hash {
+		t.Fatalf("results %d (%v), want %d", got.Hash, err, A.Hash)
+	}
+
+	s.change(B)
+	nextStreamEvent(t, events, "NewDelta", B)
+}
+
+func TestStreamUpToDate(t *testing.T) {
+	A, B, C := streamTestResults(1, 1), streamTestResults(2, 1), streamTestResults(3, 1)
+	s := newTestStream(t, A, B)
+	events := s.open(t, "", feed.FormatHash(B.Hash))
+
+	// nothing is sent until the results change
+	s.change(C)
+	e := nextStreamEvent(t, events, "NewDelta", C)
+	var delta liveo.ResultDelta
+	if err := json.Unmarshal(e.data, &delta); err != nil || delta.Old != B.Hash {
+		t.Fatalf("delta from %d (%v), want from %d", delta.Old, err, B.Hash)
+	}
+}
+
+func TestStreamCatchesUp(t *testing.T) {
+	A, B, C, D := streamTestResults(1, 1), streamTestResults(2, 1), streamTestResults(3, 1), streamTestResults(4, 1)
+	s := newTestStream(t, A, B, C)
+	events := s.open(t, "", feed.FormatHash(A.Hash))
+
+	// a single delta catches the client up
+	e := nextStreamEvent(t, events, "NewDelta", C)
+	var delta liveo.ResultDelta
+	if err := json.Unmarshal(e.data, &delta); err != nil || delta.Old != A.Hash || delta.New != C.Hash {
+		t.Fatalf("delta from %d to %d (%v), want one from %d to %d", delta.Old, delta.New, err, A.Hash, C.Hash)
+	}
+
+	s.change(D)
+	nextStreamEvent(t, events, "NewDelta", D)
+}
+
+func TestStreamCourseScope(t *testing.T) {
+	A, B, C := streamTestResults(1, 1), streamTestResults(1, 2), streamTestResults(2, 2)
+	scope := courseScope([]string{"blue"})
+	s := newTestStream(t, A)
+	events := s.open(t, "?course=blue", "")
+
+	// ids are hashes of the full results, while the results sent are of the course alone
+	e := nextStreamEvent(t, events, "NewResults", A)
+	var got liveo.ResultDataSet
+	if err := json.Unmarshal(e.data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Results.Courses) != 1 || got.Results.Courses[0].Title != "Blue" || got.Hash != A.WithCourses(scope).Hash {
+		t.Fatalf("results %d of %d courses, want Blue alone as %d", got.Hash, len(got.Results.Courses), A.WithCourses(scope).Hash)
+	}
+
+	// a change to Brown alone isn't sent
+	s.change(B)
+	s.change(C)
+	e = nextStreamEvent(t, events, "NewDelta", C)
+	var delta liveo.ResultDelta
+	if err := json.Unmarshal(e.data, &delta); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := got.Apply(delta); err != nil || after.Hash != C.WithCourses(scope).Hash {
+		t.Fatalf("delta gives %d (%v), want %d", after.Hash, err, C.WithCourses(scope).Hash)
+	}
+}
+
+func TestStreamResyncsWhenLagged(t *testing.T) {
+	A, B, C := streamTestResults(1, 1), streamTestResults(2, 1), streamTestResults(3, 1)
+	s := newTestStream(t, A)
+	events := s.open(t, "", "")
+	nextStreamEvent(t, events, "NewResults", A)
+
+	// the stream missed the change to C, and is sent the full results once it has sent B
+	s.hub.mu.RLock()
+	for _, sub := range s.hub.subscriptions {
+		atomic.StoreInt32(&sub.dropped, 1)
+	}
+	s.hub.mu.RUnlock()
+	s.mu.Lock()
+	s.current = C
+	s.mu.Unlock()
+	s.hub.broadcast(hubUpdate(A, B))
+
+	nextStreamEvent(t, events, "NewDelta", B)
+	nextStreamEvent(t, events, "NewResults", C)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// pullResults subscribes to the filewatcher at address and serves rr's API over the connection,
+// so results arrive just as they do when the filewatcher connects to us. The subscription is
+// re-established whenever the connection is lost. Without tlsSettings TLS is used only for port 443.
+func pullResults(address string, rr *resultsReceiver, tlsSettings *liveo.TLSSettings) {
+	retryDelay := time.Second
+	for {
+		conn, protocol, err := liveo.DialRPC(address, liveo.SubscribeEndpoint, tlsSettings)
+		if err != nil {
+			log.Printf("Failed to subscribe to %s: %s", address, err)
+			time.Sleep(retryDelay)
+			if retryDelay < 30*time.Second {
+				retryDelay *= 2
+			}
+			continue
+		}
+		retryDelay = time.Second
+
+		log.Printf("Subscribed to %s using protocol v%d %v", address, protocol.Version, protocol.Capabilities)
+		serveRPC(conn, protocol, rr)
+		log.Printf("Subscription to %s ended", address)
+	}
+}
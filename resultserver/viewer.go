@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fivegreenapples/live-o-results/feed"
+	"github.com/fivegreenapples/live-o-results/liveo"
+)
+
+// viewer tracks what a sockjs viewer has been sent, so that it can be sent deltas for just the
+// courses it follows. Viewers follow every course until they send:
+//
+//	Subscribe <course>    follow a course, by title or id
+//	Unsubscribe <course>  stop following a course
+//	SubscribeAll          follow every course, including any added later
+//	UnsubscribeAll        follow no courses until subscribing to some
+//
+// Results sent to a viewer keep every course, so viewers can offer courses to follow, but
+// only followed courses have competitors. Hashes are of the results as the viewer has them.
+type viewer struct {
+	mu      sync.Mutex
+	send    func(msg string)
+	encoded *resultsCache
+	// format is the version of the feed format the viewer asked for, or zero for legacy
+	// liveo JSON
+	format int
+	subs   courseSubscriptions
+	// sent is the results as the viewer has them, as far as we know
+	sent liveo.ResultDataSet
+}
+
+// courseSubscriptions records the courses a viewer follows: every course except those listed,
+// or, if not all, only those listed. Courses are listed by title or id in lower case.
+type courseSubscriptions struct {
+	all    bool
+	except map[string]bool
+}
+
+// viewCache holds, for one update, what was sent to viewers following the same courses from
+// the same results, so that other such viewers are sent the same without working it out again
+type viewCache struct {
+	mu    sync.Mutex
+	views map[string]*cachedView
+}
+
+type cachedView struct {
+	once    sync.Once
+	results liveo.ResultDataSet
+	msg     string
+}
+
+// get returns the view and message cached for key, computing them if need be
+func (c *viewCache) get(key string, compute func() (liveo.ResultDataSet, string)) (liveo.ResultDataSet, string) {
+	c.mu.Lock()
+	view, found := c.views[key]
+	if !found {
+		view = &cachedView{}
+		c.views[key] = view
+	}
+	c.mu.Unlock()
+	view.once.Do(func() {
+		view.results, view.msg = compute()
+	})
+	return view.results, view.msg
+}
+
+// resultsCache holds the messages sending recent results, so that a rush of viewers asking for
+// the same results encode them just once
+type resultsCache struct {
+	mu       sync.Mutex
+	messages map[string]string
+}
+
+// resultsCacheSize bounds the messages held by a resultsCache
+const resultsCacheSize = 16
+
+func newResultsCache() *resultsCache {
+	return &resultsCache{messages: map[string]string{}}
+}
+
+// get returns the message cached for key, encoding it if need be
+func (c *resultsCache) get(key string, encode func() string) string {
+	c.mu.Lock()
+	msg, found := c.messages[key]
+	c.mu.Unlock()
+	if found {
+		return msg
+	}
+	msg = encode()
+	c.mu.Lock()
+	if len(c.messages) >= resultsCacheSize {
+		c.messages = map[string]string{}
+	}
+	c.messages[key] = msg
+	c.mu.Unlock()
+	return msg
+}
+
+func newViewer(send func(msg string), encoded *resultsCache) *viewer {
+	return &viewer{
+		send:    send,
+		encoded: encoded,
+		subs:    courseSubscriptions{all: true, except: map[string]bool{}},
+	}
+}
+
+func (s courseSubscriptions) everything() bool {
+	return s.all && len(s.except) == 0
+}
+
+// key identifies the courses followed
+func (s courseSubscriptions) key() string {
+	except := make([]string, 0, len(s.except))
+	for course := range s.except {
+		except = append(except, course)
+	}
+	sort.Strings(except)
+	return fmt.Sprintf("%t %q", s.all, except)
+}
+
+func (s courseSubscriptions) follows(c liveo.Course) bool {
+	listed := s.except[strings.ToLower(c.Title)] || c.ID != "" && s.except[strings.ToLower(c.ID)]
+	return s.all != listed
+}
+
+// view returns rs as the viewer is to have it
+func (v *viewer) view(rs liveo.ResultDataSet) liveo.ResultDataSet {
+	if v.subs.everything() {
+		return rs
+	}
+	return rs.Following(v.subs.follows)
+}
+
+// setFormat switches the viewer to a version of the feed format, reporting whether the version
+// is supported
+func (v *viewer) setFormat(version int) bool {
+	if version != feed.Version {
+		return false
+	}
+	v.mu.Lock()
+	v.format = version
+	v.mu.Unlock()
+	return true
+}
+
+// socketEvent returns the sockjs message for an event with JSON encoded data
+func socketEvent(name string, data json.RawMessage) string {
+	return `{"Type":"Event","Msg":{"Name":"` + name + `","Data":` + string(data) + `}}`
+}
+
+func (v *viewer) sendResults(rs liveo.ResultDataSet) {
+	v.send(v.encoded.get(fmt.Sprint(v.format, rs.Hash), func() string {
+		var encoded []byte
+		if v.format == feed.Version {
+			encoded, _ = json.Marshal(feed.FromResults(rs))
+			return socketEvent("Results", encoded)
+		}
+		encoded, _ = json.Marshal(rs)
+		return socketEvent("NewResults", encoded)
+	}))
+	v.sent = rs
+}
+
+func (v *viewer) sendDelta(delta liveo.ResultDelta, after liveo.ResultDataSet) {
+	v.send(v.deltaEvent(delta, after))
+	v.sent = after
+}
+
+// deltaEvent returns the message sending delta to the viewer
+func (v *viewer) deltaEvent(delta liveo.ResultDelta, after liveo.ResultDataSet) string {
+	var encoded []byte
+	if v.format == feed.Version {
+		encoded, _ = json.Marshal(feed.FromDelta(delta, after))
+		return socketEvent("Delta", encoded)
+	}
+	encoded, _ = json.Marshal(delta)
+	return socketEvent("NewDelta", encoded)
+}
+
+// catchUp sends the viewer whatever takes it from what it was last sent to its view of rs
+func (v *viewer) catchUp(rs liveo.ResultDataSet) {
+	if v.sent.Hash == 0 {
+		// the viewer has nothing to apply a delta to until it requests results
+		return
+	}
+	after := v.view(rs)
+	if after.Hash != v.sent.Hash {
+		v.sendDelta(v.sent.DeltaTo(after), after)
+	}
+}
+
+// update sends the viewer a change of results
+func (v *viewer) update(u viewerUpdate) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.subs.everything() && (v.sent.Hash == 0 || v.sent.Hash == u.previous.Hash) {
+		// the common case, where the delta is shared by every viewer. Viewers yet to request
+		// results get it too, as they always have, though they can't have applied it.
+		if v.format == feed.Version {
+			v.send(u.feedEvent)
+		} else {
+			v.send(u.deltaEvent)
+		}
+		if v.sent.Hash != 0 {
+			v.sent = u.results
+		}
+		return
+	}
+	if v.sent.Hash == 0 {
+		return
+	}
+	key := fmt.Sprint(v.format, v.sent.Hash, v.subs.key())
+	after, msg := u.views.get(key, func() (liveo.ResultDataSet, string) {
+		after := v.view(u.results)
+		if after.Hash == v.sent.Hash {
+			return after, ""
+		}
+		return after, v.deltaEvent(v.sent.DeltaTo(after), after)
+	})
+	if msg != "" {
+		v.send(msg)
+	}
+	v.sent = after
+}
+
+// resync sends the viewer whatever it needs to catch up with current, having missed updates
+func (v *viewer) resync(current liveo.ResultDataSet) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.catchUp(current)
+}
+
+// requestResults answers a viewer's request for results. The viewer may give the hash of the
+// results it has, in which case it's sent catchUp, the delta from them to current, if caughtUp.
+func (v *viewer) requestResults(current liveo.ResultDataSet, catchUp liveo.ResultDelta, caughtUp bool, hash string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.subs.everything() {
+		if caughtUp {
+			v.sendDelta(catchUp, current)
+			return
+		}
+	} else if view := v.view(current); viewerHash(hash)(view.Hash) {
+		v.sendDelta(liveo.ResultDelta{Old: view.Hash, New: view.Hash}, view)
+		return
+	}
+	v.sendResults(v.view(current))
+}
+
+// subscribe follows or stops following a course, sending the viewer the change to its results
+func (v *viewer) subscribe(course string, follow bool, current liveo.ResultDataSet) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	course = strings.ToLower(strings.TrimSpace(course))
+	if follow == v.subs.all {
+		delete(v.subs.except, course)
+	} else {
+		v.subs.except[course] = true
+	}
+	v.catchUp(current)
+}
+
+// subscribeAll follows every course, or none
+func (v *viewer) subscribeAll(follow bool, current liveo.ResultDataSet) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.subs = courseSubscriptions{all: follow, except: map[string]bool{}}
+	v.catchUp(current)
+}